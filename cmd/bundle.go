@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/bundle"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/DockerContainerService/image-save/pkg/tools"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+var (
+	imageListFile, bundlePolicy, bundleSplitSize string
+	manifestPaths, podSpecs                      []string
+	bundleSignatures                             bool
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle [-f images.yaml] [--k8s manifests/] [flags]",
+	Short: "Save the images of a list into a directory ready to be carried to an air-gapped host",
+	Long: `Save every image of images.yaml, or of the pods defined in Kubernetes manifests, into the output directory,
+	bundle by default, with bundle.json describing the archives, SHA256SUMS and load.sh checking the bundle then loading the images`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := bundleImages(cmd)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		dir := output
+		if dir == "" {
+			dir = "bundle"
+		}
+		tools.MkdirPath(dir)
+
+		var reports []*client.Report
+		namer := bundle.NewNamer()
+		for _, image := range list.Images {
+			say("Saving image: %s\n", image.Name)
+			c, err := newClient(image.Name, bundlePolicy)
+			if err != nil {
+				logrus.Fatalf("%+v", err)
+			}
+
+			name, err := namer.Name(c, image)
+			if err != nil {
+				logrus.Fatalf("%+v", err)
+			}
+
+			osFilters, archFilters := image.Filters(archFilter)
+			report, err := c.Save(osFilters, archFilters, filepath.Join(dir, name), saveOptions(bundleSignatures, bundleSplitSize))
+			if err != nil {
+				logrus.Fatalf("%s: %+v", image.Name, err)
+			}
+			reports = append(reports, report)
+		}
+
+		m, err := bundle.Write(dir, reports)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		if jsonResult() {
+			printJson(m)
+			return
+		}
+		fmt.Printf("Bundle: %s, %d archive(s), load with %s\n", dir, len(reports), filepath.Join(dir, bundle.LoaderFile))
+	},
+}
+
+// bundleImages returns the images of the image list and of the Kubernetes manifests,
+// the image list is only read by default when no manifest is given
+func bundleImages(cmd *cobra.Command) (*bundle.ImageList, error) {
+	if len(manifestPaths) == 0 {
+		return bundle.LoadImageList(imageListFile)
+	}
+
+	list := &bundle.ImageList{}
+	if cmd.Flags().Changed("file") {
+		var err error
+		if list, err = bundle.LoadImageList(imageListFile); err != nil {
+			return nil, err
+		}
+	}
+	images, err := bundle.ScanManifests(manifestPaths, podSpecs)
+	if err != nil {
+		return nil, err
+	}
+	say("Found %d image(s) in the manifests\n", len(images))
+	for _, image := range images {
+		say("  %s\n", image)
+		list.Add(bundle.Image{Name: image})
+	}
+	if len(list.Images) == 0 {
+		return nil, fmt.Errorf("no image found")
+	}
+	return list, nil
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&imageListFile, "file", "f", "images.yaml", "list of the images to save")
+	bundleCmd.Flags().StringArrayVar(&manifestPaths, "k8s", nil, "also save the images of the Kubernetes manifests in this file or directory, like rendered Helm charts, - reads the standard input; can be repeated")
+	bundleCmd.Flags().StringArrayVar(&podSpecs, "pod-spec-path", nil, "location of the pod specs in custom resources like MyJob=spec.runner.template.spec, lists are walked through; can be repeated")
+	bundleCmd.Flags().StringVar(&bundlePolicy, "policy", "", "policy file deciding which images may be saved")
+	bundleCmd.Flags().BoolVar(&bundleSignatures, "signatures", false, "also save the cosign signatures, attestations, SBOMs and OCI referrers of the images")
+	bundleCmd.Flags().StringVar(&bundleSplitSize, "split-size", "", "split the archives into numbered volumes of at most this size like 4G or 500MiB")
+	rootCmd.AddCommand(bundleCmd)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/bundle"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// saveCompose saves every image of the services of the compose files into its own archive
+func saveCompose() {
+	project, err := bundle.LoadCompose(composeFiles, composeEnvFile, composeProfiles)
+	if err != nil {
+		logrus.Fatalf("%+v", err)
+	}
+	for _, service := range project.Skipped {
+		say("Skipped service %s: built from source without image\n", service)
+	}
+	if len(project.Images) == 0 {
+		logrus.Fatalf("no image found in the compose files")
+	}
+	if output != "" && len(project.Images) > 1 {
+		logrus.Warnf("output file is ignored when saving %d images", len(project.Images))
+		output = ""
+	}
+
+	for _, image := range project.Images {
+		say("Saving image: %s\n", image)
+		start := time.Now()
+		c, err := newClient(image, policyFile)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		report, err := c.Save(osFilters(), []string{archFilter}, output, saveOptions(signatures, splitSize))
+		if err != nil {
+			logrus.Fatalf("%s: %+v", image, err)
+		}
+		saved(report, start)
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var exportPolicy string
+
+var exportCmd = &cobra.Command{
+	Use:   "export [image] [flags]",
+	Short: "Write the flattened root filesystem of an image to a tar file or a directory",
+	Long: `Apply the layers of an image in order and write the resulting root filesystem,
+	to a tar file if the output ends with .tar, to a directory otherwise`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := newClient(args[0], exportPolicy)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+
+		start := time.Now()
+		res, err := c.Export(osFilters(), []string{archFilter}, output)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		if jsonResult() {
+			printJson(map[string]interface{}{"output": res, "duration": time.Since(start).Seconds()})
+			return
+		}
+		fmt.Printf("Output: %s\n", res)
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportPolicy, "policy", "", "policy file deciding which images may be exported")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -1,15 +1,20 @@
 package cmd
 
 import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
 	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"runtime"
+	"time"
 )
 
 var (
-	version, osFilter, archFilter, username, password, output, mirror string
-	debug, insecure                                                   bool
+	version, osFilter, archFilter, username, password, output, mirror, verifyKey, policyFile, foreignLayers, baselineFile, splitSize, composeEnvFile string
+	debug, insecure, singleArchive, sidecar, signatures, noTags, squash                                                                              bool
+	tagAs, extraTags, composeFiles, composeProfiles                                                                                                  []string
 )
 
 var rootCmd = &cobra.Command{
@@ -18,32 +23,159 @@ var rootCmd = &cobra.Command{
 	Long: `Save docker image to local without docker daemon
 	Complete documentation is available at https://github.com/DockerContainerService/image-save`,
 	Version: version,
-	Args:    cobra.ExactArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if !tagFilter().SelectsTags() && (tagSort != "" || tagLimit != 0) {
+			return fmt.Errorf("--sort and --limit need --tags or --semver")
+		}
+		if len(composeFiles) > 0 {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		if debug {
-			logrus.SetLevel(logrus.DebugLevel)
+		if len(composeFiles) > 0 {
+			saveCompose()
+			return
 		}
 
-		c, err := client.NewClient(args[0], username, password, mirror, insecure)
+		c, err := newClient(args[0], policyFile)
 		if err != nil {
 			logrus.Fatalf("%+v", err)
 		}
-		var osFilters []string
-
-		// Avoid empty osFilter
-		if osFilter == "" {
-			osFilters = []string{}
-		} else {
-			osFilters = []string{osFilter}
+		if tagFilter().SelectsTags() {
+			saveTags(c)
+			return
 		}
 
-		err = c.Save(osFilters, []string{archFilter}, output)
+		start := time.Now()
+		report, err := c.Save(osFilters(), []string{archFilter}, output, saveOptions(signatures, splitSize))
 		if err != nil {
 			logrus.Fatalf("%+v", err)
 		}
+		saved(report, start)
 	},
 }
 
+// newClient returns the client of the image, the policy file applied if any
+func newClient(image, policyFile string) (*client.Client, error) {
+	c, err := client.NewClient(image, username, password, mirror, insecure)
+	if err != nil {
+		return nil, err
+	}
+	c.SetConsole(console, progressBars)
+	if policyFile != "" {
+		p, err := client.LoadPolicy(policyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = c.SetPolicy(p); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// saveTags saves every tag of the repository matching the tag filter
+func saveTags(c *client.Client) {
+	tags, err := listTags(c)
+	if err != nil {
+		logrus.Fatalf("%+v", err)
+	}
+	if len(tags) == 0 {
+		logrus.Fatalf("no tag matched")
+	}
+	if singleArchive {
+		start := time.Now()
+		report, err := c.SaveTags(tags, osFilters(), []string{archFilter}, output, saveOptions(signatures, splitSize))
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		saved(report, start)
+		printTagSummary(report.Images)
+		return
+	}
+
+	if output != "" && len(tags) > 1 {
+		logrus.Warnf("output file is ignored when saving %d tags", len(tags))
+		output = ""
+	}
+
+	for _, tag := range tags {
+		say("Saving tag: %s\n", tag)
+		start := time.Now()
+		report, err := c.WithTag(tag).Save(osFilters(), []string{archFilter}, output, saveOptions(signatures, splitSize))
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		saved(report, start)
+	}
+}
+
+func osFilters() []string {
+	// Avoid empty osFilter
+	if osFilter == "" {
+		return []string{}
+	}
+	return []string{osFilter}
+}
+
+// saveOptions returns the options of the save flags, signatures and splitSize are the flags of the running command
+func saveOptions(signatures bool, splitSize string) *client.SaveOptions {
+	opts := &client.SaveOptions{
+		Artifacts:     signatures,
+		ForeignLayers: foreignLayers,
+		TagAs:         tagAs,
+		Tags:          extraTags,
+		NoTags:        noTags,
+		Squash:        squash,
+	}
+	if verifyKey != "" {
+		key, err := client.LoadPublicKey(verifyKey)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		opts.VerifyKey = key
+	}
+	if splitSize != "" {
+		size, err := archive.ParseSize(splitSize)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		opts.SplitSize = size
+	}
+	if baselineFile != "" {
+		baseline, err := archive.LoadBaseline(baselineFile)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		opts.Baseline = baseline
+	}
+	return opts
+}
+
+func writeSidecar(report *client.Report) {
+	if !sidecar {
+		return
+	}
+	err := report.WriteSidecar()
+	if err != nil {
+		logrus.Fatalf("%+v", err)
+	}
+}
+
+func printTagSummary(results []*client.ImageResult) {
+	if jsonResult() {
+		return
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(console)
+	t.AppendHeader(table.Row{"Tag", "Digest", "Layers", "Shared layers"})
+	for _, res := range results {
+		t.AppendRow(table.Row{res.Tag, res.Digest, len(res.Layers), res.SharedLayers})
+	}
+	t.Render()
+}
+
 func init() {
 	rootCmd.SetVersionTemplate("imsave version {{.Version}}\n")
 	rootCmd.PersistentFlags().StringVar(&archFilter, "arch", runtime.GOARCH, "the architecture of the image you want to save")
@@ -54,6 +186,25 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&insecure, "insecure", "i", false, "whether the registry is using http")
 	rootCmd.PersistentFlags().StringVarP(&mirror, "mirror", "m", "registry.hub.docker.com", "use a mirror repository")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debug mode")
+	rootCmd.Flags().StringVar(&tagRegex, "tags", "", "save every tag of the repository matching the regular expression")
+	rootCmd.Flags().StringVar(&tagSemver, "semver", "", "save every tag of the repository matching the semver range")
+	rootCmd.Flags().StringVar(&tagSort, "sort", "", "sort the matched tags by alpha or semver (newest first)")
+	rootCmd.Flags().IntVar(&tagLimit, "limit", 0, "only save the first n matched tags")
+	rootCmd.Flags().BoolVar(&signatures, "signatures", false, "also save the cosign signatures, attestations, SBOMs and OCI referrers of the image")
+	rootCmd.Flags().StringVar(&verifyKey, "verify-key", "", "only save the image if it has a cosign signature made by this public key")
+	rootCmd.Flags().StringVar(&policyFile, "policy", "", "policy file deciding which images may be saved")
+	rootCmd.Flags().StringVar(&foreignLayers, "foreign-layers", client.ForeignLayersDownload, "what to do with foreign layers of Windows images: download them from their URLs, or skip them and record them as foreign in manifest.json")
+	rootCmd.Flags().StringArrayVar(&tagAs, "tag-as", nil, "tag the image with this reference in the archive instead of its own, can be repeated; without a tag the tag of the image is kept")
+	rootCmd.Flags().StringArrayVar(&extraTags, "tag", nil, "also tag the image with this reference in the archive, can be repeated; templates like registry.internal/{{.Repo}}:{{.Tag}} are expanded")
+	rootCmd.Flags().BoolVar(&noTags, "no-tags", false, "do not tag the image with its own reference in the archive")
+	rootCmd.Flags().StringVar(&baselineFile, "exclude-layers-from", "", "leave out the layers already in this previously shipped archive or list of digests, imsave merge rebuilds the complete archive")
+	rootCmd.Flags().BoolVar(&squash, "squash", false, "merge the layers of the image into a single one")
+	rootCmd.Flags().StringVar(&splitSize, "split-size", "", "split the archive into numbered volumes of at most this size like 4G or 500MiB, listed with their checksums in <output>.parts.json")
+	rootCmd.Flags().StringArrayVar(&composeFiles, "compose", nil, "save the images of the services of this docker-compose file instead of an image, can be repeated like docker compose -f")
+	rootCmd.Flags().StringArrayVar(&composeProfiles, "profile", nil, "also save the services of this compose profile, can be repeated; COMPOSE_PROFILES by default")
+	rootCmd.Flags().StringVar(&composeEnvFile, "env-file", "", "file of the variables interpolated in the compose files, the .env file next to the first one by default")
+	rootCmd.Flags().BoolVar(&sidecar, "sidecar", false, "write <output>.sha256 and a <output>.json report next to the archive")
+	rootCmd.Flags().BoolVar(&singleArchive, "single-archive", false, "save all matched tags into one archive")
 }
 
 func Execute() {
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/dustin/go-humanize"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var inspectJson bool
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [image] [flags]",
+	Short: "Show information about a remote image without downloading its layers",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := newClient(args[0], "")
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+
+		// the platforms of a list are only listed when none matches the host, unless asked for one
+		info, err := c.Inspect(osFilters(), []string{archFilter}, cmd.Flags().Changed("os") || cmd.Flags().Changed("arch"))
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+
+		if jsonResult() {
+			printJson(info)
+			return
+		}
+		if inspectJson {
+			res, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				logrus.Fatalf("marshal inspect result error: %+v", err)
+			}
+			fmt.Println(string(res))
+			return
+		}
+		printInspect(info)
+	},
+}
+
+func printInspect(info *client.ImageInspect) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendRow(table.Row{"Reference", info.Reference})
+	t.AppendRow(table.Row{"Media type", info.MediaType})
+	t.AppendRow(table.Row{"Digest", info.Digest})
+	for _, p := range info.Platforms {
+		t.AppendRow(table.Row{"Platform", fmt.Sprintf("%s %s", platformString(p), p.Digest)})
+	}
+	if info.Selected == nil {
+		t.AppendRow(table.Row{"Selected", "none, no platform matches the host, use --os and --arch to select one"})
+		t.Render()
+		return
+	}
+	t.AppendRow(table.Row{"Selected", fmt.Sprintf("%s %s", platformString(*info.Selected), info.Selected.Digest)})
+	if info.Created != nil {
+		t.AppendRow(table.Row{"Created", info.Created.Format("2006-01-02 15:04:05 MST")})
+	}
+	t.AppendRow(table.Row{"Layers", len(info.Layers)})
+	t.AppendRow(table.Row{"Total size", humanize.Bytes(uint64(info.TotalSize))})
+	if len(info.Entrypoint) > 0 {
+		t.AppendRow(table.Row{"Entrypoint", strings.Join(info.Entrypoint, " ")})
+	}
+	if len(info.Cmd) > 0 {
+		t.AppendRow(table.Row{"Cmd", strings.Join(info.Cmd, " ")})
+	}
+	for _, e := range info.Env {
+		t.AppendRow(table.Row{"Env", e})
+	}
+	labels := make([]string, 0, len(info.Labels))
+	for k := range info.Labels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		t.AppendRow(table.Row{"Label", fmt.Sprintf("%s=%s", k, info.Labels[k])})
+	}
+	t.Render()
+
+	l := table.NewWriter()
+	l.SetOutputMirror(os.Stdout)
+	l.AppendHeader(table.Row{"#", "Digest", "Media type", "Size"})
+	for i, layer := range info.Layers {
+		l.AppendRow(table.Row{i + 1, layer.Digest, layer.MediaType, humanize.Bytes(uint64(layer.Size))})
+	}
+	l.Render()
+}
+
+func platformString(p client.PlatformInfo) string {
+	res := fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+	if p.Variant != "" {
+		res += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		res += " (" + p.OSVersion + ")"
+	}
+	return res
+}
+
+func init() {
+	inspectCmd.Flags().BoolVar(&inspectJson, "json", false, "print the result as json")
+	rootCmd.AddCommand(inspectCmd)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+var mergeBaseline string
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge [archive...] [flags]",
+	Short: "Combine archives into one, or rebuild a complete archive from a delta archive and its baseline",
+	Long: `Combine archives produced by imsave into a single one, the layers they share are written once.
+	With --baseline, add to a delta archive saved with --exclude-layers-from the layers it left in its baseline,
+	the result can be loaded by docker load`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var archives []*archive.Archive
+		for _, arg := range args {
+			a, err := archive.Open(arg)
+			if err != nil {
+				logrus.Fatalf("%+v", err)
+			}
+			archives = append(archives, a)
+		}
+
+		res := output
+		if mergeBaseline != "" {
+			if len(archives) != 1 {
+				logrus.Fatalf("--baseline takes a single delta archive")
+			}
+			baseline, err := archive.Open(mergeBaseline)
+			if err != nil {
+				logrus.Fatalf("%+v", err)
+			}
+			if res == "" {
+				res = strings.TrimSuffix(args[0], ".tgz") + "_full.tgz"
+			}
+			if err = archive.MergeBaseline(archives[0], baseline, res); err != nil {
+				logrus.Fatalf("%+v", err)
+			}
+			printOutputs(res)
+			return
+		}
+
+		if res == "" {
+			res = "merged.tgz"
+		}
+		if err := archive.Merge(archives, res); err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		printOutputs(res)
+	},
+}
+
+// printOutputs prints the files written by a command
+func printOutputs(outputs ...string) {
+	if jsonResult() {
+		printJson(map[string]interface{}{"outputs": outputs})
+		return
+	}
+	for _, res := range outputs {
+		fmt.Printf("Output: %s\n", res)
+	}
+}
+
+func init() {
+	mergeCmd.Flags().StringVar(&mergeBaseline, "baseline", "", "the archive the delta archive was saved against")
+	rootCmd.AddCommand(mergeCmd)
+}
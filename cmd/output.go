@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/DockerContainerService/image-save/pkg/tools"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	prefixed "github.com/x-cray/logrus-prefixed-formatter"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJson = "json"
+	// logLevelEnv is the log level used without --log-level
+	logLevelEnv = "IMSAVE_LOG_LEVEL"
+)
+
+var (
+	logFormat, logLevel, logFile, logFileSize string
+	logFileBackups                            int
+	quiet                                     bool
+
+	// console receives the progress messages of the commands and of their clients
+	console io.Writer = os.Stdout
+	// progressBars renders the progress bars of the downloads on console
+	progressBars = true
+)
+
+// setupOutput applies --log-format, --log-level, --log-file and --quiet. In json mode the messages are logged
+// as json on stderr and stdout only gets the result of the command as json
+func setupOutput(cmd *cobra.Command, args []string) error {
+	level, err := outputLevel()
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(level)
+	// the callers only help debugging
+	logrus.SetReportCaller(level >= logrus.DebugLevel)
+
+	switch logFormat {
+	case logFormatText:
+	case logFormatJson:
+		logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339})
+		console, progressBars = logWriter{}, false
+	default:
+		return fmt.Errorf("unsupported log format %s, use %s or %s", logFormat, logFormatText, logFormatJson)
+	}
+	if quiet {
+		console, progressBars = io.Discard, false
+	}
+	if logFile != "" {
+		return setupLogFile()
+	}
+	return nil
+}
+
+// outputLevel returns the level of --log-level, IMSAVE_LOG_LEVEL or the one implied by --quiet and --debug
+func outputLevel() (logrus.Level, error) {
+	name, from := logLevel, "--log-level"
+	if name == "" {
+		name, from = os.Getenv(logLevelEnv), logLevelEnv
+	}
+	if name == "" {
+		switch {
+		case debug:
+			return logrus.DebugLevel, nil
+		case quiet:
+			return logrus.WarnLevel, nil
+		}
+		return logrus.InfoLevel, nil
+	}
+	// fatal and panic would hide the errors
+	level, err := logrus.ParseLevel(name)
+	if err != nil || level < logrus.ErrorLevel {
+		return level, fmt.Errorf("invalid %s %s, use trace, debug, info, warn or error", from, name)
+	}
+	if debug && level < logrus.DebugLevel {
+		level = logrus.DebugLevel
+	}
+	return level, nil
+}
+
+// setupLogFile writes the logs to the rotating --log-file, the terminal keeps the progress and gets the errors
+func setupLogFile() error {
+	size, err := archive.ParseSize(logFileSize)
+	if err != nil {
+		return fmt.Errorf("invalid --log-file-size: %+v", err)
+	}
+	file, err := tools.OpenRotatingFile(logFile, size, logFileBackups)
+	if err != nil {
+		return err
+	}
+	logrus.RegisterExitHandler(func() { file.Close() })
+
+	logrus.AddHook(&terminalHook{formatter: logrus.StandardLogger().Formatter})
+	if logFormat == logFormatText {
+		logrus.SetFormatter(&prefixed.TextFormatter{
+			DisableColors:   true,
+			TimestampFormat: "2006-01-02 15:04:05",
+			FullTimestamp:   true,
+			ForceFormatting: true,
+		})
+	}
+	logrus.SetOutput(file)
+	return nil
+}
+
+// terminalHook still shows the errors on stderr when logging to a file
+type terminalHook struct {
+	formatter logrus.Formatter
+}
+
+func (h *terminalHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h *terminalHook) Fire(entry *logrus.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stderr.Write(line)
+	return err
+}
+
+// logWriter logs every line written as an info message
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			logrus.Info(line)
+		}
+	}
+	return len(p), nil
+}
+
+// say prints a progress message of a command like the client does
+func say(format string, args ...interface{}) {
+	fmt.Fprintf(console, format, args...)
+}
+
+// jsonResult reports whether the result of the command is printed as json on stdout instead of text
+func jsonResult() bool {
+	return logFormat == logFormatJson
+}
+
+// printJson prints a result on a single line of stdout
+func printJson(v interface{}) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		logrus.Fatalf("marshal result error: %+v", err)
+	}
+	fmt.Println(string(res))
+}
+
+// saveResult is the json result of a save, one per archive
+type saveResult struct {
+	*client.Report
+	// Duration is the time the save took in seconds
+	Duration float64 `json:"duration"`
+}
+
+// saved writes the sidecar of an archive saved since start and prints its json result
+func saved(report *client.Report, start time.Time) {
+	writeSidecar(report)
+	if jsonResult() {
+		printJson(saveResult{Report: report, Duration: time.Since(start).Seconds()})
+	}
+}
+
+func init() {
+	rootCmd.PersistentPreRunE = setupOutput
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText, "format of the logs: text, or json with the result of the command printed as json on stdout")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only log warnings and errors, without progress")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level: trace, debug, info, warn or error, $"+logLevelEnv+" by default")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "write the logs to this file instead of the terminal, rotated when it reaches --log-file-size")
+	rootCmd.PersistentFlags().StringVar(&logFileSize, "log-file-size", "10MB", "size of the log file before rotation")
+	rootCmd.PersistentFlags().IntVar(&logFileBackups, "log-file-backups", 3, "number of rotated log files kept")
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStdout returns what f prints on stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	defer logrus.SetOutput(os.Stderr)
+
+	io.WriteString(logWriter{}, "Saving image: alpine\n\n  Output file: alpine.tgz  \n")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+	for i, want := range []string{"Saving image: alpine", "Output file: alpine.tgz"} {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("line %d is not json: %s", i, lines[i])
+		}
+		if entry["msg"] != want || entry["level"] != "info" {
+			t.Errorf("unexpected log line: %s", lines[i])
+		}
+	}
+}
+
+func TestSavedJsonResult(t *testing.T) {
+	logFormat = logFormatJson
+	defer func() { logFormat = logFormatText }()
+
+	layer := digest.FromString("layer")
+	report := &client.Report{
+		Output: "alpine_latest.tgz",
+		Sha256: "0123",
+		Size:   42,
+		Images: []*client.ImageResult{{
+			Reference: "docker.io/library/alpine:latest",
+			Digest:    digest.FromString("manifest"),
+			Platform:  "linux/amd64",
+			Layers:    []client.LayerResult{{Digest: layer, MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Size: 40}},
+		}},
+	}
+	out := captureStdout(t, func() { saved(report, time.Now().Add(-2*time.Second)) })
+	if strings.Count(out, "\n") != 1 {
+		t.Fatalf("expected a single line, got %q", out)
+	}
+
+	var res struct {
+		Output   string  `json:"output"`
+		Sha256   string  `json:"sha256"`
+		Size     int64   `json:"size"`
+		Duration float64 `json:"duration"`
+		Images   []struct {
+			Digest digest.Digest `json:"digest"`
+			Layers []struct {
+				Digest digest.Digest `json:"digest"`
+				Size   int64         `json:"size"`
+			} `json:"layers"`
+		} `json:"images"`
+	}
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("result is not json: %s", out)
+	}
+	if res.Output != report.Output || res.Sha256 != report.Sha256 || res.Size != 42 || res.Duration < 2 {
+		t.Errorf("unexpected result: %s", out)
+	}
+	if len(res.Images) != 1 || res.Images[0].Digest != report.Images[0].Digest || len(res.Images[0].Layers) != 1 ||
+		res.Images[0].Layers[0].Digest != layer || res.Images[0].Layers[0].Size != 40 {
+		t.Errorf("unexpected images: %s", out)
+	}
+}
+
+func TestOutputLevel(t *testing.T) {
+	defer func() { logLevel = "" }()
+	for name, want := range map[string]logrus.Level{"trace": logrus.TraceLevel, "warn": logrus.WarnLevel, "error": logrus.ErrorLevel} {
+		logLevel = name
+		if level, err := outputLevel(); err != nil || level != want {
+			t.Errorf("%s: got %v, %v", name, level, err)
+		}
+	}
+	// fatal and panic would hide the errors
+	for _, name := range []string{"fatal", "panic", "verbose"} {
+		logLevel = name
+		if _, err := outputLevel(); err == nil || !strings.Contains(err.Error(), "invalid --log-level "+name) {
+			t.Errorf("%s: expected an invalid level error, got %v", name, err)
+		}
+	}
+}
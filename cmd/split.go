@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var splitDir string
+
+var splitCmd = &cobra.Command{
+	Use:   "split [archive] [flags]",
+	Short: "Write each image of a multi-image archive to its own archive",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		a, err := archive.Open(args[0])
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		outputs, err := archive.Split(a, splitDir)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		printOutputs(outputs...)
+	},
+}
+
+func init() {
+	splitCmd.Flags().StringVar(&splitDir, "dir", ".", "directory the archives are written to")
+	rootCmd.AddCommand(splitCmd)
+}
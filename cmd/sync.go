@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/bundle"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var (
+	syncDir, syncPolicy, syncSplitSize string
+	syncPrune, syncSignatures          bool
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync -f images.yaml --dir archives/ [flags]",
+	Short: "Keep a directory of archives up to date with a list of images",
+	Long: `Save the images of images.yaml whose manifest digest differs from the one recorded in the sidecar report
+	of their archive, or which have no archive yet. With --prune, the archives of the images no longer listed are removed`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := bundle.LoadImageList(imageListFile)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		results, err := bundle.Sync(list, &bundle.SyncOptions{
+			Dir:   syncDir,
+			Prune: syncPrune,
+			Arch:  archFilter,
+			NewClient: func(image string) (*client.Client, error) {
+				return newClient(image, syncPolicy)
+			},
+			Save: saveOptions(syncSignatures, syncSplitSize),
+		})
+		failed := printSyncSummary(results)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		if failed > 0 {
+			logrus.Fatalf("%d image(s) failed", failed)
+		}
+	},
+}
+
+// syncResult is the json result of sync for an image
+type syncResult struct {
+	Image   string        `json:"image"`
+	Archive string        `json:"archive"`
+	Status  string        `json:"status"`
+	Digest  digest.Digest `json:"digest,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// printSyncSummary prints the changes made by sync and returns the number of failures
+func printSyncSummary(results []bundle.SyncResult) int {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Image", "Archive", "Status", "Digest"})
+	counts := make(map[string]int)
+	jsonResults := make([]syncResult, 0, len(results))
+	for _, res := range results {
+		counts[res.Status]++
+		t.AppendRow(table.Row{res.Image, res.Archive, res.Status, res.Digest})
+		jsonRes := syncResult{Image: res.Image, Archive: res.Archive, Status: res.Status, Digest: res.Digest}
+		if res.Err != nil {
+			jsonRes.Error = res.Err.Error()
+			logrus.Errorf("%s: %+v", res.Image, res.Err)
+		}
+		jsonResults = append(jsonResults, jsonRes)
+	}
+	if jsonResult() {
+		printJson(jsonResults)
+		return counts[bundle.SyncFailed]
+	}
+	t.Render()
+	fmt.Printf("%d added, %d updated, %d unchanged, %d removed, %d failed\n",
+		counts[bundle.SyncAdded], counts[bundle.SyncUpdated], counts[bundle.SyncUnchanged], counts[bundle.SyncRemoved], counts[bundle.SyncFailed])
+	return counts[bundle.SyncFailed]
+}
+
+func init() {
+	syncCmd.Flags().StringVarP(&imageListFile, "file", "f", "images.yaml", "list of the images to keep in the directory")
+	syncCmd.Flags().StringVar(&syncDir, "dir", ".", "directory of the archives")
+	syncCmd.Flags().BoolVar(&syncPrune, "prune", false, "remove the archives of the images no longer listed")
+	syncCmd.Flags().StringVar(&syncPolicy, "policy", "", "policy file deciding which images may be saved")
+	syncCmd.Flags().BoolVar(&syncSignatures, "signatures", false, "also save the cosign signatures, attestations, SBOMs and OCI referrers of the images")
+	syncCmd.Flags().StringVar(&syncSplitSize, "split-size", "", "split the archives into numbered volumes of at most this size like 4G or 500MiB")
+	rootCmd.AddCommand(syncCmd)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tagRegex, tagSemver, tagSort string
+	tagLimit                     int
+)
+
+var tagsCmd = &cobra.Command{
+	Use:   "tags [repository] [flags]",
+	Short: "List the tags of a repository",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := newClient(args[0], "")
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+
+		tags, err := listTags(c)
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		if jsonResult() {
+			printJson(tags)
+			return
+		}
+		for _, tag := range tags {
+			fmt.Println(tag)
+		}
+	},
+}
+
+func tagFilter() *client.TagFilter {
+	return &client.TagFilter{
+		Regex:      tagRegex,
+		Constraint: tagSemver,
+		Sort:       tagSort,
+		Limit:      tagLimit,
+	}
+}
+
+func listTags(c *client.Client) ([]string, error) {
+	tags, err := c.ListTags()
+	if err != nil {
+		return nil, err
+	}
+	return tagFilter().Apply(tags)
+}
+
+func init() {
+	tagsCmd.Flags().StringVar(&tagRegex, "regex", "", "only list tags matching the regular expression")
+	tagsCmd.Flags().StringVar(&tagSemver, "semver", "", "only list tags matching the semver range, e.g. \">=1.2, <2\"")
+	tagsCmd.Flags().StringVar(&tagSort, "sort", "", "sort the tags by alpha or semver (newest first)")
+	tagsCmd.Flags().IntVar(&tagLimit, "limit", 0, "only list the first n tags")
+	rootCmd.AddCommand(tagsCmd)
+}
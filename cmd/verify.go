@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [archive] [flags]",
+	Short: "Check the integrity of an archive produced by imsave",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		a, err := archive.Open(args[0])
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+
+		problems, err := a.Verify()
+		if err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+		if jsonResult() {
+			printJson(map[string]interface{}{"archive": args[0], "images": len(a.Manifest), "problems": problems})
+			if len(problems) > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		if len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "%s\n", p)
+			}
+			fmt.Fprintf(os.Stderr, "%s: %d problem(s) found\n", args[0], len(problems))
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: %d image(s) verified\n", args[0], len(a.Manifest))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
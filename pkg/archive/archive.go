@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	ManifestFile     = "manifest.json"
+	RepositoriesFile = "repositories"
+	// ArtifactsDir holds the signatures, attestations and SBOMs of the images as an OCI layout
+	ArtifactsDir = "artifacts"
+)
+
+// ManifestEntry is an image entry of manifest.json in a docker-archive
+type ManifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+	// LayerSources describes the foreign layers by diff_id, they are not in the archive
+	LayerSources map[digest.Digest]specsv1.Descriptor `json:"LayerSources,omitempty"`
+}
+
+// Archive is the index of a docker-archive, the layers are not loaded
+type Archive struct {
+	Path         string
+	Manifest     []ManifestEntry
+	Repositories map[string]map[string]string
+
+	// Files maps every regular file of the archive to its size
+	Files map[string]int64
+	// Configs holds the content of the image configs referenced by manifest.json
+	Configs map[string][]byte
+	// Delta lists the layers left in the baseline of a delta archive, nil for complete archives
+	Delta *Delta
+	// Volumes lists the files of an archive split into volumes, nil for a single file
+	Volumes    *Volumes
+	volumesDir string
+}
+
+// Open reads the metadata of a docker-archive, gzip compressed or not. The archive may be split into volumes,
+// archivePath is then its name, its first volume or the list of its volumes
+func Open(archivePath string) (*Archive, error) {
+	a := &Archive{
+		Path:    archivePath,
+		Files:   make(map[string]int64),
+		Configs: make(map[string][]byte),
+	}
+	var err error
+	a.Volumes, a.volumesDir, err = loadVolumes(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// configs are small json files at the top level, keep them until manifest.json tells which ones are needed
+	jsonFiles := make(map[string][]byte)
+	var manifestBytes, repositoriesBytes, deltaBytes []byte
+
+	err = a.Walk(func(hdr *tar.Header, r io.Reader) error {
+		name := hdr.Name
+		a.Files[name] = hdr.Size
+
+		var err error
+		switch {
+		case name == ManifestFile:
+			manifestBytes, err = io.ReadAll(r)
+		case name == RepositoriesFile:
+			repositoriesBytes, err = io.ReadAll(r)
+		case name == DeltaFile:
+			deltaBytes, err = io.ReadAll(r)
+		case !strings.Contains(name, "/") && path.Ext(name) == ".json":
+			jsonFiles[name], err = io.ReadAll(r)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if manifestBytes == nil {
+		return nil, fmt.Errorf("%s: %s not found", archivePath, ManifestFile)
+	}
+	if err = json.Unmarshal(manifestBytes, &a.Manifest); err != nil {
+		return nil, fmt.Errorf("%s: parse %s error: %+v", archivePath, ManifestFile, err)
+	}
+	if repositoriesBytes != nil {
+		if err = json.Unmarshal(repositoriesBytes, &a.Repositories); err != nil {
+			return nil, fmt.Errorf("%s: parse %s error: %+v", archivePath, RepositoriesFile, err)
+		}
+	}
+
+	if deltaBytes != nil {
+		if err = json.Unmarshal(deltaBytes, &a.Delta); err != nil {
+			return nil, fmt.Errorf("%s: parse %s error: %+v", archivePath, DeltaFile, err)
+		}
+	}
+
+	for _, m := range a.Manifest {
+		if config, ok := jsonFiles[m.Config]; ok {
+			a.Configs[m.Config] = config
+		}
+	}
+	return a, nil
+}
+
+// Walk calls fn for every regular file of the archive in the order of the tarball
+func (a *Archive) Walk(fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := a.open()
+	if err != nil {
+		return fmt.Errorf("open archive %s error: %+v", a.Path, err)
+	}
+	defer f.Close()
+
+	r, err := Decompress(f)
+	if err != nil {
+		return fmt.Errorf("%s: %+v", a.Path, err)
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: read tar error: %+v", a.Path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		hdr.Name = strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if err = fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// magic numbers of the compressed layers
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+)
+
+// Decompress returns a reader of the uncompressed stream, whether r is gzip or zstd compressed or not
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip error: %+v", err)
+		}
+		return gr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("zstd error: %+v", err)
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, xzMagic):
+		return nil, fmt.Errorf("unsupported compression xz, only gzip and zstd are supported")
+	}
+	return io.NopCloser(br), nil
+}
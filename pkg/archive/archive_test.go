@@ -0,0 +1,203 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testFile struct {
+	name    string
+	content []byte
+}
+
+// testImage is an image of a test archive, its layers are uncompressed tarballs
+type testImage struct {
+	repoTag string
+	layers  [][]byte
+}
+
+// testLayer returns a layer tarball holding the files
+func testLayer(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, content)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testArchiveFiles returns the files of a docker-archive of the images like docker save writes them,
+// the layer dirs are named after the diff_ids
+func testArchiveFiles(images ...testImage) []testFile {
+	var files []testFile
+	var manifest []ManifestEntry
+	repositories := make(map[string]map[string]string)
+	written := make(map[string]bool)
+	for _, img := range images {
+		m := ManifestEntry{RepoTags: []string{img.repoTag}}
+		var diffIDs []digest.Digest
+		for _, layer := range img.layers {
+			diffID := digest.FromBytes(layer)
+			diffIDs = append(diffIDs, diffID)
+			m.Layers = append(m.Layers, diffID.Encoded()+"/layer.tar")
+			if !written[diffID.Encoded()] {
+				written[diffID.Encoded()] = true
+				files = append(files,
+					testFile{diffID.Encoded() + "/VERSION", []byte("1.0")},
+					testFile{diffID.Encoded() + "/json", []byte("{}")},
+					testFile{diffID.Encoded() + "/layer.tar", layer})
+			}
+		}
+
+		config, _ := json.Marshal(map[string]interface{}{
+			"architecture": "amd64",
+			"os":           "linux",
+			"config":       map[string]interface{}{"Labels": map[string]string{"image": img.repoTag}},
+			"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+		})
+		m.Config = digest.FromBytes(config).Encoded() + ".json"
+		files = append(files, testFile{m.Config, config})
+		manifest = append(manifest, m)
+
+		repo, tag, _ := strings.Cut(img.repoTag, ":")
+		if repositories[repo] == nil {
+			repositories[repo] = make(map[string]string)
+		}
+		repositories[repo][tag] = diffIDs[len(diffIDs)-1].Encoded()
+	}
+
+	manifestBytes, _ := json.Marshal(manifest)
+	repositoriesBytes, _ := json.Marshal(repositories)
+	return append(files, testFile{ManifestFile, manifestBytes}, testFile{RepositoriesFile, repositoriesBytes})
+}
+
+// writeTestTgz writes the files to a gzip compressed tarball
+func writeTestTgz(t *testing.T, path string, files []testFile) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		if err = tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write(file.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestArchive(t *testing.T, path string, images ...testImage) *Archive {
+	t.Helper()
+	writeTestTgz(t, path, testArchiveFiles(images...))
+	a, err := Open(path)
+	if err != nil {
+		t.Fatalf("open %s error: %+v", path, err)
+	}
+	return a
+}
+
+func TestOpen(t *testing.T) {
+	base := testLayer(t, map[string]string{"etc/os-release": "base"})
+	path := filepath.Join(t.TempDir(), "app.tgz")
+	a := writeTestArchive(t, path,
+		testImage{"app:1.0", [][]byte{base, testLayer(t, map[string]string{"app": "v1"})}},
+		testImage{"web:1.0", [][]byte{base}})
+
+	if len(a.Manifest) != 2 || len(a.Manifest[0].Layers) != 2 || a.Manifest[0].RepoTags[0] != "app:1.0" {
+		t.Fatalf("unexpected manifest: %+v", a.Manifest)
+	}
+	if len(a.Configs) != 2 || a.Configs[a.Manifest[1].Config] == nil {
+		t.Errorf("configs not loaded: %v", a.Configs)
+	}
+	if a.Repositories["web"]["1.0"] != digest.FromBytes(base).Encoded() {
+		t.Errorf("unexpected repositories: %v", a.Repositories)
+	}
+	if size, ok := a.Files[a.Manifest[1].Layers[0]]; !ok || size != int64(len(base)) {
+		t.Errorf("unexpected size of the base layer: %d", size)
+	}
+	if a.Delta != nil || a.Volumes != nil {
+		t.Errorf("unexpected delta or volumes: %+v %+v", a.Delta, a.Volumes)
+	}
+
+	diffIDs := a.DiffIDs()
+	if len(diffIDs) != 2 || diffIDs[1][0] != digest.FromBytes(base) {
+		t.Errorf("unexpected diff_ids: %v", diffIDs)
+	}
+}
+
+func TestOpenNotAnArchive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "files.tgz")
+	writeTestTgz(t, path, []testFile{{"README", []byte("not an image")}})
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), ManifestFile+" not found") {
+		t.Errorf("expected a missing manifest error, got %v", err)
+	}
+	if _, err := Open(filepath.Join(dir, "missing.tgz")); err == nil {
+		t.Errorf("expected an error opening a missing archive")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	io.WriteString(gw, "content")
+	gw.Close()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := zw.EncodeAll([]byte("content"), nil)
+
+	for _, test := range []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"gzip", gz.Bytes(), "content"},
+		{"zstd", zst, "content"},
+		{"plain", []byte("content"), "content"},
+		{"shorter than the magic", []byte("c"), "c"},
+	} {
+		r, err := Decompress(bytes.NewReader(test.input))
+		if err != nil {
+			t.Fatalf("%s: %+v", test.name, err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(content) != test.want {
+			t.Errorf("%s: got %q, %v", test.name, content, err)
+		}
+	}
+
+	xz := []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00, 0x00, 0x04}
+	if _, err = Decompress(bytes.NewReader(xz)); err == nil || !strings.Contains(err.Error(), "unsupported compression xz") {
+		t.Errorf("expected xz to be unsupported, got %v", err)
+	}
+}
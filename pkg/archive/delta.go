@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DeltaFile lists the layers of a delta archive left in its baseline
+const DeltaFile = "delta.json"
+
+// Delta is the content of DeltaFile
+type Delta struct {
+	// Baseline is the name of the baseline the archive was saved against
+	Baseline string `json:"baseline"`
+	// Layers maps the layer.tar paths missing from the archive to the layer they hold
+	Layers map[string]DeltaLayer `json:"layers"`
+}
+
+type DeltaLayer struct {
+	Digest digest.Digest `json:"digest"`
+	DiffID digest.Digest `json:"diffID"`
+}
+
+// Baseline is the set of layers already shipped, by blob digest or diff_id
+type Baseline struct {
+	Name    string
+	digests map[digest.Digest]bool
+}
+
+// LoadBaseline reads the layers of a previously shipped archive, or a list of digests with one digest per line
+func LoadBaseline(path string) (*Baseline, error) {
+	b := &Baseline{Name: filepath.Base(path), digests: make(map[digest.Digest]bool)}
+
+	a, archiveErr := Open(path)
+	if archiveErr == nil {
+		for i, layers := range a.DiffIDs() {
+			for j, diffID := range layers {
+				if a.holds(a.Manifest[i], j, diffID) {
+					b.digests[diffID] = true
+				}
+			}
+		}
+		return b, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open baseline %s error: %+v", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		d, err := digest.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("baseline %s is neither an archive (%+v) nor a digest list (line %d: %+v)", path, archiveErr, line, err)
+		}
+		b.digests[d] = true
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read baseline %s error: %+v", path, err)
+	}
+	return b, nil
+}
+
+// Has reports whether one of the digests of a layer is in the baseline
+func (b *Baseline) Has(digests ...digest.Digest) bool {
+	for _, d := range digests {
+		if d != "" && b.digests[d] {
+			return true
+		}
+	}
+	return false
+}
+
+// holds reports whether the archive holds the layer.tar of layer i of the image, foreign layers
+// and the layers a delta archive left in its baseline are not in the archive
+func (a *Archive) holds(m ManifestEntry, i int, diffID digest.Digest) bool {
+	if _, ok := m.LayerSources[diffID]; ok {
+		return false
+	}
+	if a.Delta != nil {
+		if _, ok := a.Delta.Layers[m.Layers[i]]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffIDs returns the diff_ids of the layers of each image of manifest.json, nil for images whose config is unreadable
+func (a *Archive) DiffIDs() [][]digest.Digest {
+	res := make([][]digest.Digest, len(a.Manifest))
+	for i, m := range a.Manifest {
+		var c rootFS
+		if json.Unmarshal(a.Configs[m.Config], &c) == nil && len(c.RootFS.DiffIDs) == len(m.Layers) {
+			res[i] = c.RootFS.DiffIDs
+		}
+	}
+	return res
+}
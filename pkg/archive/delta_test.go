@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"encoding/json"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadBaseline(t *testing.T) {
+	dir := t.TempDir()
+	base := testLayer(t, map[string]string{"etc/os-release": "base"})
+	app := testLayer(t, map[string]string{"app": "v1"})
+	path := filepath.Join(dir, "app-1.0.tgz")
+	writeTestArchive(t, path, testImage{"app:1.0", [][]byte{base, app}})
+
+	b, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatalf("load baseline error: %+v", err)
+	}
+	if b.Name != "app-1.0.tgz" || !b.Has(digest.FromBytes(base)) || !b.Has("", digest.FromBytes(app)) {
+		t.Errorf("layers of the archive missing from the baseline: %+v", b)
+	}
+	if b.Has(digest.FromString("other"), "") {
+		t.Errorf("unexpected layer in the baseline")
+	}
+
+	list := filepath.Join(dir, "shipped.txt")
+	content := "# shipped on 2024-01-01\n\n" + digest.FromBytes(base).String() + "\n  " + digest.FromBytes(app).String() + "  \n"
+	if err = os.WriteFile(list, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = LoadBaseline(list); err != nil {
+		t.Fatalf("load baseline error: %+v", err)
+	}
+	if !b.Has(digest.FromBytes(base)) || !b.Has(digest.FromBytes(app)) {
+		t.Errorf("digests of the list missing from the baseline: %+v", b)
+	}
+}
+
+func TestLoadBaselineInvalid(t *testing.T) {
+	dir := t.TempDir()
+	// a tarball which is not a docker-archive
+	files := filepath.Join(dir, "files.tgz")
+	writeTestTgz(t, files, []testFile{{"README", []byte("not an image")}})
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(text, []byte(digest.FromString("layer").String()+"\nlayer two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		files:                         "is neither an archive",
+		text:                          "line 2",
+		filepath.Join(dir, "missing"): "open baseline",
+	} {
+		if _, err := LoadBaseline(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", filepath.Base(path), want, err)
+		}
+	}
+}
+
+func TestLoadBaselineSkipsMissingLayers(t *testing.T) {
+	base := testLayer(t, map[string]string{"etc/os-release": "base"})
+	foreign := testLayer(t, map[string]string{"windows": "base"})
+	external := testLayer(t, map[string]string{"app": "v1"})
+	app := testLayer(t, map[string]string{"app": "v2"})
+
+	// the foreign layer is only described in LayerSources and the external one is left in the baseline of the archive
+	var files []testFile
+	for _, f := range testArchiveFiles(testImage{"app:2.0", [][]byte{base, foreign, external, app}}) {
+		switch f.name {
+		case digest.FromBytes(foreign).Encoded() + "/layer.tar", digest.FromBytes(external).Encoded() + "/layer.tar":
+			continue
+		case ManifestFile:
+			var manifest []ManifestEntry
+			if err := json.Unmarshal(f.content, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			manifest[0].LayerSources = map[digest.Digest]specsv1.Descriptor{digest.FromBytes(foreign): {URLs: []string{"https://example.invalid/base.tar"}}}
+			f.content, _ = json.Marshal(manifest)
+		}
+		files = append(files, f)
+	}
+	delta, _ := json.Marshal(Delta{Baseline: "app-1.0.tgz", Layers: map[string]DeltaLayer{
+		digest.FromBytes(external).Encoded() + "/layer.tar": {DiffID: digest.FromBytes(external)},
+	}})
+	path := filepath.Join(t.TempDir(), "app-2.0.tgz")
+	writeTestTgz(t, path, append(files, testFile{DeltaFile, delta}))
+
+	b, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatalf("load baseline error: %+v", err)
+	}
+	if !b.Has(digest.FromBytes(base)) || !b.Has(digest.FromBytes(app)) {
+		t.Errorf("layers of the archive missing from the baseline: %+v", b)
+	}
+	if b.Has(digest.FromBytes(foreign)) || b.Has(digest.FromBytes(external)) {
+		t.Errorf("layers missing from the archive are in the baseline: %+v", b)
+	}
+}
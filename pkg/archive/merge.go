@@ -0,0 +1,252 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// MergeBaseline writes to output the complete archive made of a delta archive and the layers it left in its baseline
+func MergeBaseline(delta, baseline *Archive, output string) error {
+	if delta.Delta == nil {
+		return fmt.Errorf("%s is not a delta archive", delta.Path)
+	}
+
+	// layer.tar of the baseline -> layer.tar paths of the delta archive holding the same layer
+	byDiffID := make(map[string]string)
+	for i, diffIDs := range baseline.DiffIDs() {
+		for j, diffID := range diffIDs {
+			if baseline.holds(baseline.Manifest[i], j, diffID) {
+				byDiffID[diffID.String()] = baseline.Manifest[i].Layers[j]
+			}
+		}
+	}
+	needed := make(map[string][]string)
+	for layer, external := range delta.Delta.Layers {
+		source, ok := byDiffID[external.DiffID.String()]
+		if !ok {
+			return fmt.Errorf("layer %s (%s) is not in the baseline %s", layer, external.DiffID, baseline.Path)
+		}
+		needed[source] = append(needed[source], layer)
+	}
+
+	return writeTgz(output, func(tw *tar.Writer) error {
+		err := delta.Walk(func(hdr *tar.Header, r io.Reader) error {
+			if hdr.Name == DeltaFile {
+				return nil
+			}
+			return copyEntry(tw, hdr, hdr.Name, r)
+		})
+		if err != nil {
+			return err
+		}
+
+		return baseline.Walk(func(hdr *tar.Header, r io.Reader) error {
+			layers := needed[hdr.Name]
+			if len(layers) == 0 {
+				return nil
+			}
+			if len(layers) == 1 {
+				return copyEntry(tw, hdr, layers[0], r)
+			}
+
+			// the layer is held by several layer dirs, keep a copy to write it several times
+			tmp, err := os.CreateTemp("", "imsave-merge-")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			if _, err = io.Copy(tmp, r); err != nil {
+				return fmt.Errorf("copy %s error: %+v", hdr.Name, err)
+			}
+			for _, layer := range layers {
+				if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				if err = copyEntry(tw, hdr, layer, tmp); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Merge writes to output a single archive holding the images of all the archives,
+// the layers and configs they share are written once
+func Merge(archives []*Archive, output string) error {
+	var manifest []ManifestEntry
+	repositories := make(map[string]map[string]string)
+	// repo tag -> config of the image it tags
+	tagged := make(map[string]string)
+	for _, a := range archives {
+		if a.Delta != nil {
+			return fmt.Errorf("%s is a delta archive, merge it with its baseline first", a.Path)
+		}
+		for _, m := range a.Manifest {
+			for _, repoTag := range m.RepoTags {
+				if config, ok := tagged[repoTag]; ok && config != m.Config {
+					return fmt.Errorf("%s tags different images in %s and a previous archive", repoTag, a.Path)
+				}
+				tagged[repoTag] = m.Config
+			}
+			manifest = mergeEntry(manifest, m)
+		}
+		for repo, tags := range a.Repositories {
+			if repositories[repo] == nil {
+				repositories[repo] = make(map[string]string)
+			}
+			for tag, id := range tags {
+				repositories[repo][tag] = id
+			}
+		}
+	}
+
+	return writeTgz(output, func(tw *tar.Writer) error {
+		if err := writeJson(tw, ManifestFile, manifest); err != nil {
+			return err
+		}
+		if len(repositories) > 0 {
+			if err := writeJson(tw, RepositoriesFile, repositories); err != nil {
+				return err
+			}
+		}
+
+		// layer dirs, configs and artifact blobs are named after their content, the first copy is kept
+		written := make(map[string]bool)
+		var index *specsv1.Index
+		for _, a := range archives {
+			err := a.Walk(func(hdr *tar.Header, r io.Reader) error {
+				switch {
+				case hdr.Name == ManifestFile || hdr.Name == RepositoriesFile || written[hdr.Name]:
+					return nil
+				case hdr.Name == artifactsIndex:
+					var err error
+					index, err = mergeIndex(index, r)
+					return err
+				}
+				written[hdr.Name] = true
+				return copyEntry(tw, hdr, hdr.Name, r)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if index != nil {
+			return writeJson(tw, artifactsIndex, index)
+		}
+		return nil
+	})
+}
+
+const artifactsIndex = ArtifactsDir + "/index.json"
+
+// mergeEntry adds an image to manifest.json, an image already listed only gets the new tags
+func mergeEntry(manifest []ManifestEntry, m ManifestEntry) []ManifestEntry {
+	for i, existing := range manifest {
+		if existing.Config != m.Config || !slices.Equal(existing.Layers, m.Layers) {
+			continue
+		}
+		for _, repoTag := range m.RepoTags {
+			if !slices.Contains(existing.RepoTags, repoTag) {
+				manifest[i].RepoTags = append(manifest[i].RepoTags, repoTag)
+			}
+		}
+		return manifest
+	}
+	return append(manifest, m)
+}
+
+// mergeIndex adds the manifests of the artifacts index.json read from r to index
+func mergeIndex(index *specsv1.Index, r io.Reader) (*specsv1.Index, error) {
+	var other specsv1.Index
+	if err := json.NewDecoder(r).Decode(&other); err != nil {
+		return nil, fmt.Errorf("parse %s error: %+v", artifactsIndex, err)
+	}
+	if index == nil {
+		return &other, nil
+	}
+	for _, desc := range other.Manifests {
+		found := slices.ContainsFunc(index.Manifests, func(m specsv1.Descriptor) bool {
+			return m.Digest == desc.Digest && m.Annotations[specsv1.AnnotationRefName] == desc.Annotations[specsv1.AnnotationRefName]
+		})
+		if !found {
+			index.Manifests = append(index.Manifests, desc)
+		}
+	}
+	return index, nil
+}
+
+// writeJson writes v as a json file of the archive
+func writeJson(tw *tar.Writer, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s error: %+v", name, err)
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	return copyEntry(tw, hdr, name, bytes.NewReader(content))
+}
+
+// writeTgz writes a gzip compressed tar to output, the file is removed if fn fails
+func writeTgz(output string, fn func(tw *tar.Writer) error) error {
+	w, err := createTgz(output)
+	if err != nil {
+		return err
+	}
+	return w.close(fn(w.Writer))
+}
+
+// tgzWriter is a gzip compressed tar being written
+type tgzWriter struct {
+	*tar.Writer
+	path string
+	f    *os.File
+	gw   *gzip.Writer
+}
+
+func createTgz(output string) (*tgzWriter, error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("create %s error: %+v", output, err)
+	}
+	gw := gzip.NewWriter(f)
+	return &tgzWriter{Writer: tar.NewWriter(gw), path: output, f: f, gw: gw}, nil
+}
+
+// close finishes the file, it is removed if err, the error of writing its content, is not nil
+func (w *tgzWriter) close(err error) error {
+	if err == nil {
+		err = w.Writer.Close()
+	}
+	if err == nil {
+		err = w.gw.Close()
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(w.path)
+		return fmt.Errorf("write %s error: %+v", w.path, err)
+	}
+	return nil
+}
+
+func copyEntry(tw *tar.Writer, hdr *tar.Header, name string, r io.Reader) error {
+	out := *hdr
+	out.Name = name
+	if err := tw.WriteHeader(&out); err != nil {
+		return fmt.Errorf("write %s error: %+v", name, err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("write %s error: %+v", name, err)
+	}
+	return nil
+}
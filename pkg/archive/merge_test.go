@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeSplit(t *testing.T) {
+	dir := t.TempDir()
+	base := testLayer(t, map[string]string{"etc/os-release": "base"})
+	app := writeTestArchive(t, filepath.Join(dir, "app.tgz"), testImage{"app:1.0", [][]byte{base, testLayer(t, map[string]string{"app": "v1"})}})
+	web := writeTestArchive(t, filepath.Join(dir, "web.tgz"), testImage{"web:1.0", [][]byte{base}})
+
+	merged := filepath.Join(dir, "merged.tgz")
+	if err := Merge([]*Archive{app, web, app}, merged); err != nil {
+		t.Fatalf("merge error: %+v", err)
+	}
+	all, err := Open(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := all.Verify(); err != nil || len(problems) > 0 {
+		t.Fatalf("merged archive: %q %+v", problems, err)
+	}
+	if len(all.Manifest) != 2 || len(all.Files) != len(app.Files)+1 {
+		t.Errorf("unexpected merged archive: %+v, %d files", all.Manifest, len(all.Files))
+	}
+
+	outputs, err := Split(all, dir)
+	if err != nil {
+		t.Fatalf("split error: %+v", err)
+	}
+	if !reflect.DeepEqual(outputs, []string{filepath.Join(dir, "app_1.0.tgz"), filepath.Join(dir, "web_1.0.tgz")}) {
+		t.Fatalf("unexpected archives: %v", outputs)
+	}
+	for i, want := range []*Archive{app, web} {
+		a, err := Open(outputs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(a.Manifest, want.Manifest) || !reflect.DeepEqual(a.Repositories, want.Repositories) || !reflect.DeepEqual(a.Files, want.Files) {
+			t.Errorf("%s differs from the original archive", outputs[i])
+		}
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	dir := t.TempDir()
+	v1 := writeTestArchive(t, filepath.Join(dir, "v1.tgz"), testImage{"app:latest", [][]byte{testLayer(t, map[string]string{"app": "v1"})}})
+	v2 := writeTestArchive(t, filepath.Join(dir, "v2.tgz"), testImage{"app:latest", [][]byte{testLayer(t, map[string]string{"app": "v2"})}})
+	if err := Merge([]*Archive{v1, v2}, filepath.Join(dir, "merged.tgz")); err == nil || !strings.Contains(err.Error(), "app:latest tags different images") {
+		t.Errorf("expected a tag conflict, got %v", err)
+	}
+
+	v2.Delta = &Delta{Baseline: "v1.tgz"}
+	if err := Merge([]*Archive{v1, v2}, filepath.Join(dir, "merged.tgz")); err == nil || !strings.Contains(err.Error(), "delta archive") {
+		t.Errorf("expected delta archives to be rejected, got %v", err)
+	}
+}
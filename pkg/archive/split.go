@@ -0,0 +1,140 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Split writes each image of the archive to its own archive in dir and returns their paths.
+// The artifacts are not linked to the images in a docker-archive, every archive gets all of them
+func Split(a *Archive, dir string) ([]string, error) {
+	// file -> images needing it
+	needed := make(map[string][]int)
+	need := func(name string, i int) {
+		if images := needed[name]; len(images) == 0 || images[len(images)-1] != i {
+			needed[name] = append(images, i)
+		}
+	}
+	layerDirs := make(map[string][]int)
+	var outputs []string
+	used := make(map[string]bool)
+	for i, m := range a.Manifest {
+		need(m.Config, i)
+		for _, layer := range m.Layers {
+			if images := layerDirs[path.Dir(layer)]; len(images) == 0 || images[len(images)-1] != i {
+				layerDirs[path.Dir(layer)] = append(images, i)
+			}
+		}
+
+		name := splitName(m)
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", splitName(m), n)
+		}
+		used[name] = true
+		outputs = append(outputs, filepath.Join(dir, name+".tgz"))
+	}
+	for name := range a.Files {
+		if strings.HasPrefix(name, ArtifactsDir+"/") {
+			for i := range a.Manifest {
+				need(name, i)
+			}
+		}
+		for _, i := range layerDirs[path.Dir(name)] {
+			need(name, i)
+		}
+	}
+
+	var writers []*tgzWriter
+	closeAll := func(err error) error {
+		for _, w := range writers {
+			if closeErr := w.close(err); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+	for i, output := range outputs {
+		w, err := createTgz(output)
+		if err != nil {
+			return nil, closeAll(err)
+		}
+		writers = append(writers, w)
+		if err = a.writeSplitMetadata(w.Writer, a.Manifest[i]); err != nil {
+			return nil, closeAll(err)
+		}
+	}
+
+	err := a.Walk(func(hdr *tar.Header, r io.Reader) error {
+		var tws []io.Writer
+		for _, i := range needed[hdr.Name] {
+			if err := writers[i].WriteHeader(hdr); err != nil {
+				return fmt.Errorf("write %s error: %+v", hdr.Name, err)
+			}
+			tws = append(tws, writers[i])
+		}
+		if _, err := io.Copy(io.MultiWriter(tws...), r); err != nil {
+			return fmt.Errorf("write %s error: %+v", hdr.Name, err)
+		}
+		return nil
+	})
+	if err = closeAll(err); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// writeSplitMetadata writes manifest.json, repositories and delta.json of the archive of a single image
+func (a *Archive) writeSplitMetadata(tw *tar.Writer, m ManifestEntry) error {
+	if err := writeJson(tw, ManifestFile, []ManifestEntry{m}); err != nil {
+		return err
+	}
+
+	repositories := make(map[string]map[string]string)
+	for repo, tags := range a.Repositories {
+		for tag, id := range tags {
+			if !slices.Contains(m.RepoTags, repo+":"+tag) {
+				continue
+			}
+			if repositories[repo] == nil {
+				repositories[repo] = make(map[string]string)
+			}
+			repositories[repo][tag] = id
+		}
+	}
+	if len(repositories) > 0 {
+		if err := writeJson(tw, RepositoriesFile, repositories); err != nil {
+			return err
+		}
+	}
+
+	if a.Delta == nil {
+		return nil
+	}
+	delta := &Delta{Baseline: a.Delta.Baseline, Layers: make(map[string]DeltaLayer)}
+	for _, layer := range m.Layers {
+		if external, ok := a.Delta.Layers[layer]; ok {
+			delta.Layers[layer] = external
+		}
+	}
+	if len(delta.Layers) == 0 {
+		return nil
+	}
+	return writeJson(tw, DeltaFile, delta)
+}
+
+// splitName names the archive of an image after its first tag like imsave does, untagged images after their config
+func splitName(m ManifestEntry) string {
+	if len(m.RepoTags) == 0 {
+		id := strings.TrimSuffix(m.Config, ".json")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		return "image_" + id
+	}
+	return strings.NewReplacer("/", "_", ":", "_").Replace(m.RepoTags[0])
+}
@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	"io"
+	"strings"
+)
+
+type rootFS struct {
+	RootFS struct {
+		DiffIDs []digest.Digest `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// Verify checks that every file referenced by the archive exists and matches its digest,
+// it returns a description of each problem found
+func (a *Archive) Verify() ([]string, error) {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if a.Volumes != nil {
+		problems = append(problems, a.verifyVolumes()...)
+	}
+	if len(a.Manifest) == 0 {
+		addProblem("%s: no image", ManifestFile)
+	}
+
+	// layer path -> expected diff id
+	diffIDs := make(map[string]digest.Digest)
+	for i, m := range a.Manifest {
+		image := fmt.Sprintf("%s[%d]", ManifestFile, i)
+		config, ok := a.Configs[m.Config]
+		if !ok {
+			addProblem("%s: config %s not found", image, m.Config)
+			continue
+		}
+
+		configDigest := digest.NewDigestFromEncoded(digest.SHA256, strings.TrimSuffix(m.Config, ".json"))
+		if err := configDigest.Validate(); err != nil {
+			addProblem("%s: config name %s is not a digest", image, m.Config)
+		} else if actual := digest.FromBytes(config); actual != configDigest {
+			addProblem("%s: config %s has digest %s", image, m.Config, actual)
+		}
+
+		var c rootFS
+		if err := json.Unmarshal(config, &c); err != nil {
+			addProblem("%s: parse config %s error: %+v", image, m.Config, err)
+			continue
+		}
+		if len(c.RootFS.DiffIDs) != len(m.Layers) {
+			addProblem("%s: config %s has %d diff_ids but %d layers are listed", image, m.Config, len(c.RootFS.DiffIDs), len(m.Layers))
+			continue
+		}
+
+		for j, layer := range m.Layers {
+			if _, ok := m.LayerSources[c.RootFS.DiffIDs[j]]; ok {
+				// foreign layer, the host loading the archive has to provide it
+				continue
+			}
+			if a.Delta != nil {
+				if external, ok := a.Delta.Layers[layer]; ok {
+					if _, ok = a.Files[layer]; !ok && external.DiffID == c.RootFS.DiffIDs[j] {
+						// left in the baseline, merge restores it
+						continue
+					}
+				}
+			}
+			if _, ok := a.Files[layer]; !ok {
+				addProblem("%s: layer %s not found", image, layer)
+				continue
+			}
+			if expected, ok := diffIDs[layer]; ok && expected != c.RootFS.DiffIDs[j] {
+				addProblem("%s: layer %s is expected to be both %s and %s", image, layer, expected, c.RootFS.DiffIDs[j])
+				continue
+			}
+			diffIDs[layer] = c.RootFS.DiffIDs[j]
+		}
+	}
+
+	for repo, tags := range a.Repositories {
+		for tag, id := range tags {
+			if _, ok := a.Files[fmt.Sprintf("%s/json", id)]; !ok {
+				addProblem("%s: %s:%s points to missing layer %s", RepositoriesFile, repo, tag, id)
+			}
+		}
+	}
+
+	verified := make(map[string]bool)
+	err := a.Walk(func(hdr *tar.Header, r io.Reader) error {
+		expected, ok := diffIDs[hdr.Name]
+		if !ok {
+			return nil
+		}
+		verified[hdr.Name] = true
+
+		actual, err := DiffID(r)
+		if err != nil {
+			addProblem("%s: %+v", hdr.Name, err)
+		} else if actual != expected {
+			addProblem("%s: diff_id is %s, expected %s", hdr.Name, actual, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for layer := range diffIDs {
+		if !verified[layer] {
+			addProblem("%s: layer was not readable", layer)
+		}
+	}
+	return problems, nil
+}
+
+// DiffID computes the digest of the uncompressed content of a layer
+func DiffID(r io.Reader) (digest.Digest, error) {
+	ur, err := Decompress(r)
+	if err != nil {
+		return "", err
+	}
+	defer ur.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, ur); err != nil {
+		return "", fmt.Errorf("read layer error: %+v", err)
+	}
+	return digest.NewDigest(digest.SHA256, h), nil
+}
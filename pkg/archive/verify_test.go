@@ -0,0 +1,87 @@
+package archive
+
+import (
+	"bytes"
+	"github.com/opencontainers/go-digest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	base := testLayer(t, map[string]string{"etc/os-release": "base"})
+	app := testLayer(t, map[string]string{"app": "v1"})
+	baseLayer := digest.FromBytes(base).Encoded() + "/layer.tar"
+	appLayer := digest.FromBytes(app).Encoded() + "/layer.tar"
+
+	tests := []struct {
+		name string
+		// change alters the files of the archive, the problems are expected to contain want
+		change func(files []testFile) []testFile
+		want   []string
+	}{
+		{"valid", func(files []testFile) []testFile { return files }, nil},
+		{"corrupted layer", func(files []testFile) []testFile {
+			for i := range files {
+				if files[i].name == appLayer {
+					files[i].content = testLayer(t, map[string]string{"app": "v2"})
+				}
+			}
+			return files
+		}, []string{appLayer + ": diff_id is"}},
+		{"truncated layer", func(files []testFile) []testFile {
+			for i := range files {
+				if files[i].name == baseLayer {
+					files[i].content = files[i].content[:100]
+				}
+			}
+			return files
+		}, []string{baseLayer + ": "}},
+		{"missing layer", func(files []testFile) []testFile {
+			return dropFile(files, appLayer)
+		}, []string{"layer " + appLayer + " not found"}},
+		{"tampered config", func(files []testFile) []testFile {
+			for i := range files {
+				if strings.HasSuffix(files[i].name, ".json") && !strings.Contains(files[i].name, "/") && files[i].name != ManifestFile {
+					files[i].content = bytes.Replace(files[i].content, []byte("amd64"), []byte("arm64"), 1)
+				}
+			}
+			return files
+		}, []string{"has digest"}},
+		{"missing layer json", func(files []testFile) []testFile {
+			return dropFile(files, digest.FromBytes(app).Encoded()+"/json")
+		}, []string{RepositoriesFile + ": app:1.0 points to missing layer"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.tgz")
+			writeTestTgz(t, path, test.change(testArchiveFiles(testImage{"app:1.0", [][]byte{base, app}})))
+			a, err := Open(path)
+			if err != nil {
+				t.Fatalf("open error: %+v", err)
+			}
+			problems, err := a.Verify()
+			if err != nil {
+				t.Fatalf("verify error: %+v", err)
+			}
+			if len(problems) != len(test.want) {
+				t.Fatalf("expected %d problems, got %q", len(test.want), problems)
+			}
+			for i, want := range test.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("expected %q in %q", want, problems[i])
+				}
+			}
+		})
+	}
+}
+
+func dropFile(files []testFile, name string) []testFile {
+	var res []testFile
+	for _, f := range files {
+		if f.name != name {
+			res = append(res, f)
+		}
+	}
+	return res
+}
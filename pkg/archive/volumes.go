@@ -0,0 +1,207 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VolumesSuffix is appended to the name of an archive split into volumes to name the file listing them
+const VolumesSuffix = ".parts.json"
+
+// Volumes lists the numbered files an archive was split into, <name>.001, <name>.002...
+type Volumes struct {
+	// Name is the name of the archive once the volumes are concatenated
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	Parts  []Part `json:"parts"`
+}
+
+// Part is a volume of an archive
+type Part struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// ParseSize reads a volume size like 4G, 500MiB or 1048576, G is 10^9 bytes and GiB 2^30
+func ParseSize(s string) (int64, error) {
+	size, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s: %+v", s, err)
+	}
+	if size == 0 {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+	return int64(size), nil
+}
+
+// SplitVolumes replaces the file at path by volumes of at most size bytes and the list of the volumes
+func SplitVolumes(path string, size int64) (*Volumes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s error: %+v", path, err)
+	}
+	defer f.Close()
+
+	v := &Volumes{Name: filepath.Base(path)}
+	total := sha256.New()
+	for n := 1; ; n++ {
+		partPath := fmt.Sprintf("%s.%03d", path, n)
+		part, err := writePart(partPath, io.TeeReader(io.LimitReader(f, size), total))
+		if err != nil {
+			return nil, err
+		}
+		// a file of a multiple of size bytes leaves an empty last part
+		if part.Size == 0 && n > 1 {
+			os.Remove(partPath)
+			break
+		}
+		v.Parts = append(v.Parts, *part)
+		v.Size += part.Size
+		if part.Size < size {
+			break
+		}
+	}
+	v.Sha256 = hex.EncodeToString(total.Sum(nil))
+
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s%s error: %+v", path, VolumesSuffix, err)
+	}
+	if err = os.WriteFile(path+VolumesSuffix, content, 0644); err != nil {
+		return nil, fmt.Errorf("write %s%s error: %+v", path, VolumesSuffix, err)
+	}
+	f.Close()
+	if err = os.Remove(path); err != nil {
+		return nil, fmt.Errorf("remove %s error: %+v", path, err)
+	}
+	return v, nil
+}
+
+func writePart(path string, r io.Reader) (*Part, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create %s error: %+v", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return nil, fmt.Errorf("write %s error: %+v", path, err)
+	}
+	if err = f.Close(); err != nil {
+		return nil, fmt.Errorf("write %s error: %+v", path, err)
+	}
+	return &Part{Name: filepath.Base(path), Size: n, Sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// loadVolumes reads the volumes of the archive at path, which can be the archive name, its first volume
+// or the list of volumes. It returns nil when the archive is a single file
+func loadVolumes(path string) (*Volumes, string, error) {
+	listPath := path
+	switch {
+	case strings.HasSuffix(path, VolumesSuffix):
+	case strings.HasSuffix(path, ".001"):
+		listPath = strings.TrimSuffix(path, ".001") + VolumesSuffix
+	default:
+		if _, err := os.Stat(path); err == nil {
+			return nil, "", nil
+		}
+		listPath = path + VolumesSuffix
+	}
+
+	content, err := os.ReadFile(listPath)
+	if os.IsNotExist(err) && listPath != path {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("read volumes %s error: %+v", listPath, err)
+	}
+	var v Volumes
+	if err = json.Unmarshal(content, &v); err != nil {
+		return nil, "", fmt.Errorf("parse volumes %s error: %+v", listPath, err)
+	}
+	if len(v.Parts) == 0 {
+		return nil, "", fmt.Errorf("%s lists no volume", listPath)
+	}
+	return &v, filepath.Dir(listPath), nil
+}
+
+// open returns the content of the archive, the volumes are read one after the other
+func (a *Archive) open() (io.ReadCloser, error) {
+	if a.Volumes == nil {
+		return os.Open(a.Path)
+	}
+	return &volumesReader{dir: a.volumesDir, parts: a.Volumes.Parts}, nil
+}
+
+type volumesReader struct {
+	dir   string
+	parts []Part
+	cur   *os.File
+}
+
+func (r *volumesReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(filepath.Join(r.dir, r.parts[0].Name))
+			if err != nil {
+				return 0, fmt.Errorf("open volume %s error: %+v", r.parts[0].Name, err)
+			}
+			r.cur, r.parts = f, r.parts[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *volumesReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// verifyVolumes checks the size and checksum of each volume
+func (a *Archive) verifyVolumes() []string {
+	var problems []string
+	for _, part := range a.Volumes.Parts {
+		f, err := os.Open(filepath.Join(a.volumesDir, part.Name))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("volume %s: %+v", part.Name, err))
+			continue
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		f.Close()
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("volume %s: read error: %+v", part.Name, err))
+		case n != part.Size:
+			problems = append(problems, fmt.Sprintf("volume %s: size is %d instead of %d", part.Name, n, part.Size))
+		case hex.EncodeToString(h.Sum(nil)) != part.Sha256:
+			problems = append(problems, fmt.Sprintf("volume %s: sha256 mismatch", part.Name))
+		}
+	}
+	return problems
+}
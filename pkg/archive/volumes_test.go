@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  int64
+	}{
+		{"1048576", 1048576},
+		{"4G", 4000000000},
+		{"4GB", 4000000000},
+		{"500MiB", 500 << 20},
+		{"1.5k", 1500},
+		{"2 GiB", 2 << 30},
+		{"0", 0},
+		{"", 0},
+		{"-1G", 0},
+		{"4X", 0},
+		{"G", 0},
+	} {
+		size, err := ParseSize(test.input)
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", test.input, size)
+			}
+			continue
+		}
+		if err != nil || size != test.want {
+			t.Errorf("%q: got %d, %v, want %d", test.input, size, err, test.want)
+		}
+	}
+}
+
+// splitTestArchive writes an archive of random content split into volumes of 16KiB
+func splitTestArchive(t *testing.T) (string, *Volumes) {
+	t.Helper()
+	content := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(content)
+	path := filepath.Join(t.TempDir(), "app.tgz")
+	writeTestTgz(t, path, testArchiveFiles(testImage{"app:1.0", [][]byte{testLayer(t, map[string]string{"data": string(content)})}}))
+
+	volumes, err := SplitVolumes(path, 16<<10)
+	if err != nil {
+		t.Fatalf("split error: %+v", err)
+	}
+	if len(volumes.Parts) < 4 || volumes.Parts[0].Name != "app.tgz.001" {
+		t.Fatalf("unexpected volumes: %+v", volumes)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the archive split into volumes was not removed")
+	}
+	return path, volumes
+}
+
+func TestVolumes(t *testing.T) {
+	path, volumes := splitTestArchive(t)
+	var size int64
+	for i, part := range volumes.Parts {
+		if i < len(volumes.Parts)-1 && part.Size != 16<<10 {
+			t.Errorf("%s: unexpected size %d", part.Name, part.Size)
+		}
+		size += part.Size
+	}
+	if size != volumes.Size {
+		t.Errorf("the volumes hold %d bytes instead of %d", size, volumes.Size)
+	}
+
+	for _, name := range []string{path, path + ".001", path + VolumesSuffix} {
+		a, err := Open(name)
+		if err != nil {
+			t.Fatalf("open %s error: %+v", filepath.Base(name), err)
+		}
+		if a.Volumes == nil || len(a.Manifest) != 1 {
+			t.Errorf("%s: unexpected archive %+v", filepath.Base(name), a)
+		}
+		if problems, err := a.Verify(); err != nil || len(problems) > 0 {
+			t.Errorf("%s: %v %+v", filepath.Base(name), problems, err)
+		}
+	}
+}
+
+func TestVolumesMissingPart(t *testing.T) {
+	path, volumes := splitTestArchive(t)
+	missing := volumes.Parts[1].Name
+	if err := os.Remove(filepath.Join(filepath.Dir(path), missing)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "open volume "+missing) {
+		t.Errorf("expected the missing volume %s to be reported, got %v", missing, err)
+	}
+}
+
+func TestVolumesReordered(t *testing.T) {
+	path, volumes := splitTestArchive(t)
+	volumes.Parts[1], volumes.Parts[2] = volumes.Parts[2], volumes.Parts[1]
+	content, _ := json.Marshal(volumes)
+	if err := os.WriteFile(path+VolumesSuffix, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Errorf("expected an error reading reordered volumes")
+	}
+}
+
+func TestVolumesCorruptedPart(t *testing.T) {
+	path, volumes := splitTestArchive(t)
+	last := volumes.Parts[len(volumes.Parts)-1]
+	// the tail of the archive is tar padding, the archive stays readable
+	partPath := filepath.Join(filepath.Dir(path), last.Name)
+	content, err := os.ReadFile(partPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(partPath, append(content, 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Open(path)
+	if err != nil {
+		t.Fatalf("open error: %+v", err)
+	}
+	problems, err := a.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "volume "+last.Name+": size is") {
+		t.Errorf("unexpected problems: %q", problems)
+	}
+}
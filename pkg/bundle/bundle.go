@@ -0,0 +1,135 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/DockerContainerService/image-save/pkg/tools"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// ManifestFile describes the archives of a bundle directory
+	ManifestFile = "bundle.json"
+	// ChecksumsFile lists the checksums of the archives in the sha256sum format
+	ChecksumsFile = "SHA256SUMS"
+	// LoaderFile checks the bundle then loads its archives
+	LoaderFile = "load.sh"
+)
+
+// Manifest is the content of ManifestFile
+type Manifest struct {
+	Created  time.Time `json:"created"`
+	Archives []Archive `json:"archives"`
+}
+
+// Archive is an archive of the bundle
+type Archive struct {
+	// File is the name of the archive in the bundle directory
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// Volumes are the files the archive was split into, File does not exist then
+	Volumes []archive.Part        `json:"volumes,omitempty"`
+	Images  []*client.ImageResult `json:"images"`
+}
+
+// Namer names the archives of the images of a list, an image listed for several platforms gets the platform in its name
+type Namer struct {
+	used map[string]bool
+}
+
+func NewNamer() *Namer {
+	return &Namer{used: make(map[string]bool)}
+}
+
+// Name returns the name of the archive of the image, c is its client
+func (n *Namer) Name(c *client.Client, image Image) (string, error) {
+	name := c.ArchiveName()
+	if n.used[name] {
+		name = fmt.Sprintf("%s_%s.tgz", strings.TrimSuffix(name, ".tgz"), strings.ReplaceAll(image.Platform, "/", "_"))
+	}
+	if n.used[name] {
+		return "", fmt.Errorf("%s is listed twice", image.Name)
+	}
+	n.used[name] = true
+	return name, nil
+}
+
+// Write describes the archives of the reports, which were saved in dir, with ManifestFile, ChecksumsFile and LoaderFile
+func Write(dir string, reports []*client.Report) (*Manifest, error) {
+	m := &Manifest{Created: time.Now().UTC()}
+	var sums strings.Builder
+	for _, r := range reports {
+		if filepath.Clean(filepath.Dir(r.Output)) != filepath.Clean(dir) {
+			return nil, fmt.Errorf("%s is not in the bundle directory %s", r.Output, dir)
+		}
+		a := Archive{File: filepath.Base(r.Output), Size: r.Size, Sha256: r.Sha256, Volumes: r.Volumes, Images: r.Images}
+		if a.Sha256 == "" {
+			sum, err := tools.Sha256File(r.Output)
+			if err != nil {
+				return nil, err
+			}
+			a.Sha256 = sum
+		}
+		if len(a.Volumes) == 0 {
+			fmt.Fprintf(&sums, "%s  %s\n", a.Sha256, a.File)
+		}
+		for _, part := range a.Volumes {
+			fmt.Fprintf(&sums, "%s  %s\n", part.Sha256, part.Name)
+		}
+		m.Archives = append(m.Archives, a)
+	}
+
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s error: %+v", ManifestFile, err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, ManifestFile), content, 0644); err != nil {
+		return nil, fmt.Errorf("write %s error: %+v", ManifestFile, err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, ChecksumsFile), []byte(sums.String()), 0644); err != nil {
+		return nil, fmt.Errorf("write %s error: %+v", ChecksumsFile, err)
+	}
+
+	var loader strings.Builder
+	if err = loaderTemplate.Execute(&loader, m); err != nil {
+		return nil, fmt.Errorf("generate %s error: %+v", LoaderFile, err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, LoaderFile), []byte(loader.String()), 0755); err != nil {
+		return nil, fmt.Errorf("write %s error: %+v", LoaderFile, err)
+	}
+	return m, nil
+}
+
+// shellQuote quotes a file name for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var loaderTemplate = template.Must(template.New(LoaderFile).Funcs(template.FuncMap{"quote": shellQuote}).Parse(`#!/bin/sh
+# Generated by imsave bundle, checks that the bundle is complete then loads its images.
+# Usage: ./load.sh [--verify-only], set LOADER=podman to load with podman
+set -e
+cd "$(dirname "$0")"
+LOADER="${LOADER:-docker}"
+
+echo "Verifying {{len .Archives}} archive(s)"
+sha256sum -c ` + ChecksumsFile + `
+if [ "$1" = "--verify-only" ]; then
+	exit 0
+fi
+{{range .Archives}}
+echo "Loading {{.File}}"
+{{- if .Volumes}}
+cat{{range .Volumes}} {{quote .Name}}{{end}} | "$LOADER" load
+{{- else}}
+"$LOADER" load -i {{quote .File}}
+{{- end}}
+{{- end}}
+`))
@@ -0,0 +1,108 @@
+package bundle
+
+import (
+	"encoding/json"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadImageList(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "images.yaml")
+	os.WriteFile(path, []byte(`
+platform: linux/amd64
+images:
+  - nginx:1.25
+  - name: registry.internal/app/api:2.0
+    platform: linux/arm/v7
+  - nginx:1.25
+`), 0644)
+	l, err := LoadImageList(path)
+	if err != nil {
+		t.Fatalf("load error: %+v", err)
+	}
+	expected := []Image{{Name: "nginx:1.25", Platform: "linux/amd64"}, {Name: "registry.internal/app/api:2.0", Platform: "linux/arm/v7"}}
+	if !reflect.DeepEqual(l.Images, expected) {
+		t.Errorf("unexpected images: %+v", l.Images)
+	}
+	if os, arch := l.Images[1].Filters("amd64"); !reflect.DeepEqual(os, []string{"linux"}) || !reflect.DeepEqual(arch, []string{"arm:v7"}) {
+		t.Errorf("unexpected filters: %v %v", os, arch)
+	}
+
+	os.WriteFile(path, []byte("- alpine\n- busybox\n"), 0644)
+	if l, err = LoadImageList(path); err != nil || len(l.Images) != 2 {
+		t.Errorf("unexpected plain list: %+v %+v", l, err)
+	}
+	if _, arch := l.Images[0].Filters("arm64"); !reflect.DeepEqual(arch, []string{"arm64"}) {
+		t.Errorf("unexpected default architecture: %v", arch)
+	}
+
+	os.WriteFile(path, []byte("images:\n  - name: ''\n"), 0644)
+	if _, err = LoadImageList(path); err == nil {
+		t.Errorf("expected an error for an image without name")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	reg := registrytest.New()
+	defer reg.Close()
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"app": "v1"})))
+	reg.PushImage("team/web", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"web": "v1"})))
+
+	bundleDir := filepath.Join(dir, "bundle")
+	os.Mkdir(bundleDir, 0755)
+	var reports []*client.Report
+	for i, ref := range []string{"team/app:1.0", "team/web:1.0"} {
+		c, err := client.NewClient(reg.Host()+"/"+ref, "", "", "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := &client.SaveOptions{}
+		if i == 1 {
+			opts.SplitSize = 200
+		}
+		report, err := c.Save(nil, []string{"amd64"}, filepath.Join(bundleDir, c.ArchiveName()), opts)
+		if err != nil {
+			t.Fatalf("save error: %+v", err)
+		}
+		reports = append(reports, report)
+	}
+
+	if _, err := Write(bundleDir, reports); err != nil {
+		t.Fatalf("write error: %+v", err)
+	}
+	var m Manifest
+	content, _ := os.ReadFile(filepath.Join(bundleDir, ManifestFile))
+	if err := json.Unmarshal(content, &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Archives) != 2 || m.Archives[0].Sha256 == "" || m.Archives[0].Images[0].Digest == "" || len(m.Archives[1].Volumes) < 2 {
+		t.Fatalf("unexpected manifest: %s", content)
+	}
+	sums, _ := os.ReadFile(filepath.Join(bundleDir, ChecksumsFile))
+	if lines := strings.Count(string(sums), "\n"); lines != 1+len(m.Archives[1].Volumes) {
+		t.Errorf("unexpected checksums: %s", sums)
+	}
+
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not found")
+	}
+	out, err := exec.Command("sh", filepath.Join(bundleDir, LoaderFile), "--verify-only").CombinedOutput()
+	if err != nil {
+		t.Errorf("verify error: %+v %s", err, out)
+	}
+	os.Remove(filepath.Join(bundleDir, m.Archives[1].Volumes[1].Name))
+	if out, err = exec.Command("sh", filepath.Join(bundleDir, LoaderFile), "--verify-only").CombinedOutput(); err == nil {
+		t.Errorf("incomplete bundle verified: %s", out)
+	}
+}
@@ -0,0 +1,276 @@
+package bundle
+
+import (
+	"bufio"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ComposeProject is the part of docker-compose files telling which images the services run
+type ComposeProject struct {
+	// Images are the images of the enabled services, each once
+	Images []string
+	// Skipped are the enabled services built from source without image name
+	Skipped []string
+}
+
+type composeService struct {
+	Image    string      `yaml:"image"`
+	Build    interface{} `yaml:"build"`
+	Profiles []string    `yaml:"profiles"`
+}
+
+// LoadCompose reads compose files, the later ones overriding the services of the former like docker compose -f does.
+// Variables are interpolated from the environment and from envFile, the .env file next to the first compose file
+// by default. Services with profiles are only enabled by one of the profiles, COMPOSE_PROFILES by default
+func LoadCompose(files []string, envFile string, profiles []string) (*ComposeProject, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no compose file")
+	}
+	if envFile == "" {
+		envFile = filepath.Join(filepath.Dir(files[0]), ".env")
+		if _, err := os.Stat(envFile); err != nil {
+			envFile = ""
+		}
+	}
+	env, err := loadEnv(envFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		if value, ok := env("COMPOSE_PROFILES"); ok && value != "" {
+			profiles = strings.Split(value, ",")
+		}
+	}
+
+	services := make(map[string]*composeService)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read compose file %s error: %+v", file, err)
+		}
+		var project struct {
+			Services map[string]composeService `yaml:"services"`
+		}
+		if err = yaml.Unmarshal(content, &project); err != nil {
+			return nil, fmt.Errorf("parse compose file %s error: %+v", file, err)
+		}
+		for name, override := range project.Services {
+			if override.Image, err = interpolate(override.Image, env); err != nil {
+				return nil, fmt.Errorf("%s: service %s: %+v", file, name, err)
+			}
+			for i := range override.Profiles {
+				if override.Profiles[i], err = interpolate(override.Profiles[i], env); err != nil {
+					return nil, fmt.Errorf("%s: service %s: %+v", file, name, err)
+				}
+			}
+
+			s, ok := services[name]
+			if !ok {
+				s = &composeService{}
+				services[name] = s
+			}
+			if override.Image != "" {
+				s.Image = override.Image
+			}
+			if override.Build != nil {
+				s.Build = override.Build
+			}
+			if override.Profiles != nil {
+				s.Profiles = override.Profiles
+			}
+		}
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	p := &ComposeProject{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		s := services[name]
+		if !profileEnabled(s.Profiles, profiles) {
+			continue
+		}
+		if s.Image == "" {
+			if s.Build != nil {
+				p.Skipped = append(p.Skipped, name)
+				continue
+			}
+			return nil, fmt.Errorf("service %s has neither image nor build", name)
+		}
+		if normalized := normalizedName(s.Image); !seen[normalized] {
+			seen[normalized] = true
+			p.Images = append(p.Images, s.Image)
+		}
+	}
+	return p, nil
+}
+
+func profileEnabled(serviceProfiles, profiles []string) bool {
+	if len(serviceProfiles) == 0 {
+		return true
+	}
+	for _, p := range profiles {
+		for _, sp := range serviceProfiles {
+			if p == "*" || strings.TrimSpace(p) == sp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadEnv returns the lookup of variables, the environment wins over the env file like with docker compose
+func loadEnv(envFile string) (func(string) (string, bool), error) {
+	vars := make(map[string]string)
+	if envFile != "" {
+		f, err := os.Open(envFile)
+		if err != nil {
+			return nil, fmt.Errorf("open env file %s error: %+v", envFile, err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: line %d: missing =", envFile, line)
+			}
+			vars[strings.TrimSpace(key)] = envValue(strings.TrimSpace(value))
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read env file %s error: %+v", envFile, err)
+		}
+	}
+	return func(key string) (string, bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			return value, true
+		}
+		value, ok := vars[key]
+		return value, ok
+	}, nil
+}
+
+// envValue unquotes a value of an env file, unquoted values end at an inline comment
+func envValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+// interpolate substitutes $VAR, ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?error}, ${VAR?error},
+// ${VAR:+replacement} and ${VAR+replacement}, $$ is a literal $
+func interpolate(s string, env func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		next := s[i+1]
+		switch {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("unclosed variable in %s", s)
+			}
+			value, err := expand(s[i+2:end], env)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i = end
+		case isNameChar(next, true):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j], false) {
+				j++
+			}
+			value, _ := env(s[i+1 : j])
+			b.WriteString(value)
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+// expand substitutes the content of ${...}
+func expand(expr string, env func(string) (string, bool)) (string, error) {
+	n := 0
+	for n < len(expr) && isNameChar(expr[n], n == 0) {
+		n++
+	}
+	name, modifier := expr[:n], expr[n:]
+	if name == "" {
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+	value, set := env(name)
+	if modifier == "" {
+		return value, nil
+	}
+
+	op, arg := modifier[:1], modifier[1:]
+	empty := !set
+	if op == ":" && len(modifier) > 1 {
+		op, arg = modifier[:2], modifier[2:]
+		empty = value == ""
+	}
+	switch op {
+	case ":-", "-":
+		if empty {
+			return interpolate(arg, env)
+		}
+	case ":?", "?":
+		if empty {
+			return "", fmt.Errorf("variable %s: %s", name, arg)
+		}
+	case ":+", "+":
+		if !empty {
+			return interpolate(arg, env)
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+	return value, nil
+}
+
+// closingBrace returns the index of the } closing the variable starting at start, nested ones included
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
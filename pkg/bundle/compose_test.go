@@ -0,0 +1,98 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadCompose(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".env"), []byte(`
+# versions
+export REGISTRY=registry.internal
+APP_TAG="2.0"
+DB_TAG=16 # postgres
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte(`
+x-app: &app
+  image: ${REGISTRY}/app/api:${APP_TAG:-latest}
+services:
+  api:
+    <<: *app
+  worker:
+    <<: *app
+  db:
+    image: postgres:$DB_TAG
+  cache:
+    image: redis:${REDIS_TAG-7.2}
+  frontend:
+    build: ./frontend
+  debug:
+    image: busybox
+    profiles: [debug]
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "docker-compose.prod.yml"), []byte(`
+services:
+  frontend:
+    image: ${REGISTRY}/app/frontend:${APP_TAG}
+  metrics:
+    image: prom/prometheus:${PROM_TAG:?PROM_TAG is required}
+    profiles: [monitoring]
+`), 0644)
+	t.Setenv("APP_TAG", "2.1")
+	t.Setenv("PROM_TAG", "v2.48.0")
+	t.Setenv("COMPOSE_PROFILES", "")
+
+	p, err := LoadCompose([]string{filepath.Join(dir, "docker-compose.yml")}, "", nil)
+	if err != nil {
+		t.Fatalf("load error: %+v", err)
+	}
+	expected := []string{"registry.internal/app/api:2.1", "redis:7.2", "postgres:16"}
+	if !reflect.DeepEqual(p.Images, expected) || !reflect.DeepEqual(p.Skipped, []string{"frontend"}) {
+		t.Errorf("unexpected project: %+v", p)
+	}
+
+	files := []string{filepath.Join(dir, "docker-compose.yml"), filepath.Join(dir, "docker-compose.prod.yml")}
+	if p, err = LoadCompose(files, "", []string{"monitoring", "debug"}); err != nil {
+		t.Fatalf("load error: %+v", err)
+	}
+	expected = []string{"registry.internal/app/api:2.1", "redis:7.2", "postgres:16", "busybox", "registry.internal/app/frontend:2.1", "prom/prometheus:v2.48.0"}
+	if !reflect.DeepEqual(p.Images, expected) || len(p.Skipped) != 0 {
+		t.Errorf("unexpected project: %+v", p)
+	}
+
+	os.Unsetenv("PROM_TAG")
+	if _, err = LoadCompose(files, "", []string{"monitoring"}); err == nil {
+		t.Errorf("expected an error for a required variable")
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	env := func(key string) (string, bool) {
+		value, ok := map[string]string{"SET": "value", "EMPTY": ""}[key]
+		return value, ok
+	}
+	for expr, expected := range map[string]string{
+		"$SET":                 "value",
+		"${SET}-x":             "value-x",
+		"$$SET":                "$SET",
+		"${EMPTY:-default}":    "default",
+		"${EMPTY-default}":     "",
+		"${UNSET-${SET}}":      "value",
+		"${SET:+replaced}":     "replaced",
+		"${UNSET:+replaced}":   "",
+		"price: 5$":            "price: 5$",
+		"${UNSET:-a}${SET}end": "avalueend",
+	} {
+		if res, err := interpolate(expr, env); err != nil || res != expected {
+			t.Errorf("%s: expected %q, got %q %+v", expr, expected, res, err)
+		}
+	}
+	for _, expr := range []string{"${EMPTY:?missing}", "${SET", "${}"} {
+		if _, err := interpolate(expr, env); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
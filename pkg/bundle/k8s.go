@@ -0,0 +1,190 @@
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// workloadPodSpecs locates the pod specs of the built-in workload kinds
+var workloadPodSpecs = map[string][]string{
+	"Pod":                   {"spec"},
+	"PodTemplate":           {"template.spec"},
+	"Deployment":            {"spec.template.spec"},
+	"StatefulSet":           {"spec.template.spec"},
+	"DaemonSet":             {"spec.template.spec"},
+	"ReplicaSet":            {"spec.template.spec"},
+	"ReplicationController": {"spec.template.spec"},
+	"Job":                   {"spec.template.spec"},
+	"CronJob":               {"spec.jobTemplate.spec.template.spec"},
+}
+
+// podSpecPath is a pod spec location given by the user, [Kind=]field.field...
+type podSpecPath struct {
+	kind   string
+	fields []string
+}
+
+func parsePodSpecPath(s string) (podSpecPath, error) {
+	var p podSpecPath
+	path := s
+	if kind, rest, ok := strings.Cut(s, "="); ok {
+		p.kind, path = kind, rest
+	}
+	for _, field := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		// lists are walked through, spec.workers[*].template and spec.workers.template are the same
+		field = strings.TrimSuffix(strings.TrimSuffix(field, "[*]"), "[]")
+		if field == "" {
+			return p, fmt.Errorf("invalid pod spec path %s", s)
+		}
+		p.fields = append(p.fields, field)
+	}
+	return p, nil
+}
+
+// ScanManifests collects the images of the pods defined in the Kubernetes manifests found at paths, files or
+// directories of .yaml, .yml and .json files, - for the standard input. Rendered Helm charts are multi-document
+// YAML files like any other. Custom resources need podSpecPaths locating their pod specs, written [Kind=]field.field...,
+// list fields are walked through. The images are returned once, in the order they were found
+func ScanManifests(paths, podSpecPaths []string) ([]string, error) {
+	s := &manifestScanner{seen: make(map[string]bool)}
+	for _, p := range podSpecPaths {
+		parsed, err := parsePodSpecPath(p)
+		if err != nil {
+			return nil, err
+		}
+		s.custom = append(s.custom, parsed)
+	}
+
+	for _, path := range paths {
+		if path == "-" {
+			if err := s.scan("stdin", os.Stdin); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err := filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			// files named on the command line are read whatever their extension
+			if ext := filepath.Ext(file); file != path && ext != ".yaml" && ext != ".yml" && ext != ".json" {
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return s.scan(file, f)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.images, nil
+}
+
+type manifestScanner struct {
+	custom []podSpecPath
+	images []string
+	seen   map[string]bool
+}
+
+func (s *manifestScanner) scan(name string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read %s error: %+v", name, err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for n := 1; ; n++ {
+		var doc interface{}
+		err = decoder.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: parse document %d error: %+v, Helm charts have to be rendered with helm template first", name, n, err)
+		}
+		s.scanObject(name, doc)
+	}
+}
+
+func (s *manifestScanner) scanObject(name string, obj interface{}) {
+	kind, _ := field(obj, "kind").(string)
+	// List and the lists of a kind like DeploymentList
+	if strings.HasSuffix(kind, "List") && field(obj, "items") != nil {
+		for _, item := range lookup(obj, []string{"items"}) {
+			s.scanObject(name, item)
+		}
+		return
+	}
+
+	for _, path := range workloadPodSpecs[kind] {
+		for _, spec := range lookup(obj, strings.Split(path, ".")) {
+			s.scanPodSpec(name, spec)
+		}
+	}
+	for _, path := range s.custom {
+		if path.kind != "" && path.kind != kind {
+			continue
+		}
+		for _, spec := range lookup(obj, path.fields) {
+			s.scanPodSpec(name, spec)
+		}
+	}
+}
+
+func (s *manifestScanner) scanPodSpec(name string, spec interface{}) {
+	for _, containers := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		for _, image := range lookup(spec, []string{containers, "image"}) {
+			ref, ok := image.(string)
+			if !ok || ref == "" {
+				continue
+			}
+			if _, err := reference.ParseNormalizedNamed(ref); err != nil {
+				logrus.Warnf("%s: skip invalid image reference %s: %+v", name, ref, err)
+				continue
+			}
+			if normalized := normalizedName(ref); !s.seen[normalized] {
+				s.seen[normalized] = true
+				s.images = append(s.images, ref)
+			}
+		}
+	}
+}
+
+// lookup returns the values found at the path of fields, lists are walked through
+func lookup(v interface{}, fields []string) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		var res []interface{}
+		for _, item := range list {
+			res = append(res, lookup(item, fields)...)
+		}
+		return res
+	}
+	if len(fields) == 0 {
+		if v == nil {
+			return nil
+		}
+		return []interface{}{v}
+	}
+	return lookup(field(v, fields[0]), fields[1:])
+}
+
+// field returns a field of a YAML mapping, nil if v is not a mapping
+func field(v interface{}, name string) interface{} {
+	if m, ok := v.(map[interface{}]interface{}); ok {
+		return m[name]
+	}
+	return nil
+}
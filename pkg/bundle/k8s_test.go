@@ -0,0 +1,102 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const helmOutput = `---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: registry.internal/app/migrate:1.0
+      containers:
+        - name: api
+          image: registry.internal/app/api:1.0
+        - name: proxy
+          image: nginx
+---
+# Source: app/templates/cronjob.yaml
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: docker.io/library/nginx:latest
+            - name: cleanup
+              image: busybox:1.36
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  image: not-an-image:1.0
+`
+
+const customResource = `apiVersion: v1
+kind: List
+items:
+  - apiVersion: apps/v1
+    kind: StatefulSet
+    spec:
+      template:
+        spec:
+          containers:
+            - image: redis:7.2
+  - apiVersion: example.com/v1
+    kind: Pipeline
+    spec:
+      stages:
+        - template:
+            spec:
+              containers:
+                - image: alpine:3.18
+        - template:
+            spec:
+              containers:
+                - image: golang:1.21
+`
+
+func TestScanManifests(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "chart"), 0755)
+	os.WriteFile(filepath.Join(dir, "chart", "rendered.yaml"), []byte(helmOutput), 0644)
+	os.WriteFile(filepath.Join(dir, "pipeline.yml"), []byte(customResource), 0644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("not: [yaml"), 0644)
+
+	images, err := ScanManifests([]string{dir}, []string{"Pipeline=spec.stages[*].template.spec"})
+	if err != nil {
+		t.Fatalf("scan error: %+v", err)
+	}
+	expected := []string{"registry.internal/app/migrate:1.0", "registry.internal/app/api:1.0", "nginx", "busybox:1.36", "redis:7.2", "alpine:3.18", "golang:1.21"}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("unexpected images: %v", images)
+	}
+
+	// custom resources are ignored without a pod spec path
+	images, err = ScanManifests([]string{filepath.Join(dir, "pipeline.yml")}, nil)
+	if err != nil || !reflect.DeepEqual(images, []string{"redis:7.2"}) {
+		t.Errorf("unexpected images: %v %+v", images, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "template.yaml"), []byte("image: {{ .Values.image }\n  bad: [\n"), 0644)
+	if _, err = ScanManifests([]string{dir}, nil); err == nil {
+		t.Errorf("expected an error for an unrendered template")
+	}
+}
@@ -0,0 +1,112 @@
+package bundle
+
+import (
+	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"gopkg.in/yaml.v2"
+	"os"
+	"strings"
+)
+
+// ImageList is the content of an images.yaml file
+//
+//	platform: linux/amd64
+//	images:
+//	  - nginx:1.25
+//	  - name: registry.internal/app/api:2.0
+//	    platform: linux/arm64
+type ImageList struct {
+	// Platform is the default platform of the images, the architecture of the host if empty
+	Platform string  `yaml:"platform"`
+	Images   []Image `yaml:"images"`
+}
+
+// Image is an image of the list, given as a reference or a mapping
+type Image struct {
+	Name     string `yaml:"name"`
+	Platform string `yaml:"platform"`
+}
+
+func (i *Image) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&i.Name); err == nil {
+		return nil
+	}
+	type plain Image
+	return unmarshal((*plain)(i))
+}
+
+// LoadImageList reads an images.yaml file, a plain list of references is accepted too
+func LoadImageList(path string) (*ImageList, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read image list %s error: %+v", path, err)
+	}
+
+	var l ImageList
+	if err = yaml.UnmarshalStrict(content, &l.Images); err != nil {
+		l = ImageList{}
+		if err = yaml.UnmarshalStrict(content, &l); err != nil {
+			return nil, fmt.Errorf("parse image list %s error: %+v", path, err)
+		}
+	}
+
+	images := l.Images
+	l.Images = nil
+	for n, image := range images {
+		image.Name = strings.TrimSpace(image.Name)
+		if image.Name == "" {
+			return nil, fmt.Errorf("%s: image %d has no name", path, n+1)
+		}
+		l.Add(image)
+	}
+	if len(l.Images) == 0 {
+		return nil, fmt.Errorf("%s lists no image", path)
+	}
+	return &l, nil
+}
+
+// Add appends the images not listed yet, nginx and docker.io/library/nginx:latest are the same image.
+// Images without platform get the default one of the list
+func (l *ImageList) Add(images ...Image) {
+	for _, image := range images {
+		if image.Platform == "" {
+			image.Platform = l.Platform
+		}
+		listed := false
+		for _, other := range l.Images {
+			if other.Platform == image.Platform && normalizedName(other.Name) == normalizedName(image.Name) {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			l.Images = append(l.Images, image)
+		}
+	}
+}
+
+// normalizedName is the full reference of an image name, the name itself if it is not valid
+func normalizedName(name string) string {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name
+	}
+	return reference.TagNameOnly(named).String()
+}
+
+// Filters returns the os and architecture filters of the platform of the image like linux/arm/v7,
+// the architecture defaults to defaultArch
+func (i Image) Filters(defaultArch string) (osFilters, archFilters []string) {
+	if i.Platform == "" {
+		return nil, []string{defaultArch}
+	}
+	parts := strings.SplitN(i.Platform, "/", 3)
+	if len(parts) == 1 {
+		return nil, []string{parts[0]}
+	}
+	arch := parts[1]
+	if len(parts) == 3 {
+		arch += ":" + parts[2]
+	}
+	return []string{parts[0]}, []string{arch}
+}
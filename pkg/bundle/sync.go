@@ -0,0 +1,191 @@
+package bundle
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/opencontainers/go-digest"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Status of an archive after Sync
+const (
+	SyncAdded     = "added"
+	SyncUpdated   = "updated"
+	SyncUnchanged = "unchanged"
+	SyncRemoved   = "removed"
+	SyncFailed    = "failed"
+)
+
+// SyncOptions tunes Sync
+type SyncOptions struct {
+	// Dir holds the archives and their sidecar reports
+	Dir string
+	// Prune removes the archives of images no longer listed, only archives with a report are considered
+	Prune bool
+	// Arch is the architecture of the images listed without platform
+	Arch string
+	// NewClient returns the client of an image
+	NewClient func(image string) (*client.Client, error)
+	Save      *client.SaveOptions
+}
+
+// SyncResult is what Sync did with the archive of an image
+type SyncResult struct {
+	Image   string
+	Archive string
+	Status  string
+	Digest  digest.Digest
+	Err     error
+}
+
+// Sync makes the archives of the directory mirror the list: the images whose manifest digest differs from the one
+// recorded in the sidecar report of their archive are saved again with a new report
+func Sync(list *ImageList, opts *SyncOptions) ([]SyncResult, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("create %s error: %+v", opts.Dir, err)
+	}
+
+	var results []SyncResult
+	namer := NewNamer()
+	listed := make(map[string]bool)
+	for _, image := range list.Images {
+		res := SyncResult{Image: image.Name}
+		if err := syncImage(image, opts, namer, &res); err != nil {
+			res.Status, res.Err = SyncFailed, err
+		}
+		listed[res.Archive] = true
+		results = append(results, res)
+	}
+	if !opts.Prune {
+		return results, nil
+	}
+	// the archive of an image failing before being named could be taken for a stale one
+	if listed[""] {
+		return results, fmt.Errorf("stale archives not removed as some images failed")
+	}
+
+	entries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return results, fmt.Errorf("read %s error: %+v", opts.Dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") || strings.HasSuffix(entry.Name(), archive.VolumesSuffix) {
+			continue
+		}
+		report, err := client.ReadReport(filepath.Join(opts.Dir, entry.Name()))
+		if err != nil || entry.Name() != filepath.Base(report.Output)+".json" || listed[filepath.Base(report.Output)] {
+			continue
+		}
+		res := SyncResult{Image: report.Images[0].Reference, Archive: filepath.Base(report.Output), Status: SyncRemoved, Digest: report.Images[0].Digest}
+		if err = removeArchive(opts.Dir, report, nil); err != nil {
+			res.Status, res.Err = SyncFailed, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func syncImage(image Image, opts *SyncOptions, namer *Namer, res *SyncResult) error {
+	c, err := opts.NewClient(image.Name)
+	if err != nil {
+		return err
+	}
+	if res.Archive, err = namer.Name(c, image); err != nil {
+		return err
+	}
+	if res.Digest, err = c.Digest(); err != nil {
+		return err
+	}
+
+	output := filepath.Join(opts.Dir, res.Archive)
+	osFilters, archFilters := image.Filters(opts.Arch)
+	res.Status = SyncAdded
+	var old *client.Report
+	if _, err = os.Stat(output + ".json"); err == nil {
+		if old, err = client.ReadReport(output + ".json"); err != nil {
+			return err
+		}
+		if old.Images[0].Digest == res.Digest && platformMatches(old.Images[0].Platform, osFilters, archFilters) && archiveComplete(opts.Dir, old) {
+			res.Status = SyncUnchanged
+			return nil
+		}
+		res.Status = SyncUpdated
+	}
+
+	report, err := c.Save(osFilters, archFilters, output, opts.Save)
+	if err != nil {
+		return err
+	}
+	if err = report.WriteSidecar(); err != nil {
+		return err
+	}
+	if old != nil {
+		return removeArchive(opts.Dir, old, report)
+	}
+	return nil
+}
+
+// platformMatches reports whether the platform of a report, like linux/arm/v7, is the one of the filters
+func platformMatches(platform string, osFilters, archFilters []string) bool {
+	parts := strings.SplitN(platform, "/", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	if len(osFilters) > 0 && osFilters[0] != parts[0] {
+		return false
+	}
+	if len(archFilters) == 0 {
+		return true
+	}
+	// the variant only matters when asked for
+	arch := parts[1]
+	if !strings.Contains(archFilters[0], ":") {
+		arch, _, _ = strings.Cut(arch, "/")
+	}
+	return strings.Replace(archFilters[0], ":", "/", 1) == arch
+}
+
+// archiveComplete reports whether the archive of a report, or all its volumes, are in dir
+func archiveComplete(dir string, r *client.Report) bool {
+	for _, file := range archiveFiles(r) {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// archiveFiles returns the files holding the archive of a report
+func archiveFiles(r *client.Report) []string {
+	if len(r.Volumes) == 0 {
+		return []string{filepath.Base(r.Output)}
+	}
+	files := []string{filepath.Base(r.Output) + archive.VolumesSuffix}
+	for _, part := range r.Volumes {
+		files = append(files, part.Name)
+	}
+	return files
+}
+
+// removeArchive removes the files of the archive of old and its sidecar, except the ones of the archive of replacement
+func removeArchive(dir string, old, replacement *client.Report) error {
+	files := append(archiveFiles(old), filepath.Base(old.Output)+".sha256", filepath.Base(old.Output)+".json")
+	keep := make(map[string]bool)
+	if replacement != nil {
+		for _, file := range append(archiveFiles(replacement), filepath.Base(replacement.Output)+".sha256", filepath.Base(replacement.Output)+".json") {
+			keep[file] = true
+		}
+	}
+	for _, file := range files {
+		if keep[file] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s error: %+v", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package bundle
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/client"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	reg := registrytest.New()
+	defer reg.Close()
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"app": "v1"})))
+	reg.PushImage("team/web", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"web": "v1"})))
+
+	archives := filepath.Join(dir, "archives")
+	opts := &SyncOptions{
+		Dir:  archives,
+		Arch: "amd64",
+		NewClient: func(image string) (*client.Client, error) {
+			return client.NewClient(image, "", "", "", true)
+		},
+	}
+	list := &ImageList{}
+	list.Add(Image{Name: reg.Host() + "/team/app:1.0"}, Image{Name: reg.Host() + "/team/web:1.0"})
+	sync := func(expected ...string) []SyncResult {
+		t.Helper()
+		results, err := Sync(list, opts)
+		if err != nil {
+			t.Fatalf("sync error: %+v", err)
+		}
+		if len(results) != len(expected) {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		for i, res := range results {
+			if res.Status != expected[i] {
+				t.Errorf("%s: expected %s, got %s %+v", res.Image, expected[i], res.Status, res.Err)
+			}
+		}
+		return results
+	}
+
+	results := sync(SyncAdded, SyncAdded)
+	if _, err := os.Stat(filepath.Join(archives, results[0].Archive+".json")); err != nil {
+		t.Errorf("sidecar report not written: %+v", err)
+	}
+	sync(SyncUnchanged, SyncUnchanged)
+
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"app": "v2"})))
+	results = sync(SyncUpdated, SyncUnchanged)
+	report, err := client.ReadReport(filepath.Join(archives, results[0].Archive+".json"))
+	if err != nil || report.Images[0].Digest != results[0].Digest {
+		t.Errorf("report not updated: %+v %+v", report, err)
+	}
+
+	// a missing archive is saved again
+	os.Remove(filepath.Join(archives, results[1].Archive))
+	sync(SyncUnchanged, SyncUpdated)
+
+	list.Images = list.Images[:1]
+	opts.Prune = true
+	sync(SyncUnchanged, SyncRemoved)
+	entries, _ := os.ReadDir(archives)
+	if len(entries) != 3 {
+		t.Errorf("expected the archive of app and its sidecar, got %v", entries)
+	}
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/tools"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// blobSuffix marks the layer blobs downloaded but not moved into a layer dir yet
+const blobSuffix = ".download"
+
+type manifestBody struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+	// LayerSources describes the foreign layers by diff_id, like docker save does
+	LayerSources map[digest.Digest]specsv1.Descriptor `json:"LayerSources,omitempty"`
+}
+
+// archiveWriter stages a docker-archive holding one or more images in a directory,
+// layers shared by several images are only written once
+type archiveWriter struct {
+	dir string
+
+	manifest     []manifestBody
+	repositories map[string]map[string]string
+	layers       map[string]bool
+	// blobs maps the layer blobs downloaded to their path in the staging dir
+	blobs map[digest.Digest]string
+	// diff_ids of the blobs downloaded, schema1 images have no config listing them
+	diffIDs map[digest.Digest]digest.Digest
+
+	artifacts *ociLayoutWriter
+	// delta lists the layers left in the baseline, nil for a complete archive
+	delta *archive.Delta
+}
+
+func newArchiveWriter(dir string) (*archiveWriter, error) {
+	if tools.IsPathExist(dir) {
+		err := tools.RemovePath(dir)
+		if err != nil {
+			return nil, fmt.Errorf("target dir already exists: %s", dir)
+		}
+	}
+	tools.MkdirPath(dir)
+
+	return &archiveWriter{
+		dir:          dir,
+		repositories: make(map[string]map[string]string),
+		layers:       make(map[string]bool),
+		blobs:        make(map[digest.Digest]string),
+		diffIDs:      make(map[digest.Digest]digest.Digest),
+		artifacts:    newOCILayoutWriter(filepath.Join(dir, ArtifactsDir)),
+	}, nil
+}
+
+// addLayer reports whether the layer dir still has to be written
+func (w *archiveWriter) addLayer(layerDirId string) bool {
+	if w.layers[layerDirId] {
+		return false
+	}
+	w.layers[layerDirId] = true
+	return true
+}
+
+// addBlob returns the path the blob is downloaded to, relative to the staging dir, and whether it is already there
+func (w *archiveWriter) addBlob(d digest.Digest) (string, bool) {
+	if file, ok := w.blobs[d]; ok {
+		return file, true
+	}
+	file := d.Encoded() + blobSuffix
+	w.blobs[d] = file
+	return file, false
+}
+
+// placeBlob moves a downloaded blob to the layer.tar of a layer dir, the next layer dirs using the same blob get a hard link
+func (w *archiveWriter) placeBlob(d digest.Digest, layerFile string) error {
+	file, ok := w.blobs[d]
+	if !ok {
+		return fmt.Errorf("blob %s was not downloaded", d)
+	}
+	if !strings.HasSuffix(file, blobSuffix) {
+		err := os.Link(filepath.Join(w.dir, file), filepath.Join(w.dir, layerFile))
+		if err != nil {
+			return fmt.Errorf("link %s error: %+v", layerFile, err)
+		}
+		return nil
+	}
+
+	err := os.Rename(filepath.Join(w.dir, file), filepath.Join(w.dir, layerFile))
+	if err != nil {
+		return fmt.Errorf("move %s error: %+v", layerFile, err)
+	}
+	w.blobs[d] = layerFile
+	return nil
+}
+
+func (w *archiveWriter) addManifest(m manifestBody) {
+	w.manifest = append(w.manifest, m)
+}
+
+// addExternal records a layer left in the baseline, the layer dir has no layer.tar
+func (w *archiveWriter) addExternal(baseline, layerFile string, layer archive.DeltaLayer) {
+	if w.delta == nil {
+		w.delta = &archive.Delta{Baseline: baseline, Layers: make(map[string]archive.DeltaLayer)}
+	}
+	w.delta.Layers[layerFile] = layer
+}
+
+// tagged reports whether an image of the archive already has the tag
+func (w *archiveWriter) tagged(name, tag string) bool {
+	_, ok := w.repositories[name][tag]
+	return ok
+}
+
+func (w *archiveWriter) addRepository(name, tag, layerDirId string) {
+	if _, ok := w.repositories[name]; !ok {
+		w.repositories[name] = make(map[string]string)
+	}
+	w.repositories[name][tag] = layerDirId
+}
+
+// close writes the archive metadata, packs the staging dir into output and removes it
+func (w *archiveWriter) close(output string) error {
+	// blobs whose layer was already in the archive under another blob, e.g. compressed differently
+	for _, file := range w.blobs {
+		if strings.HasSuffix(file, blobSuffix) {
+			tools.RemovePath(filepath.Join(w.dir, file))
+		}
+	}
+
+	logrus.Debugf("create manifest.json")
+	manifestByte, err := json.Marshal(w.manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifestJson error: %+v", err)
+	}
+	tools.WriteFile(fmt.Sprintf("%s/manifest.json", w.dir), manifestByte)
+
+	logrus.Debugf("create repositories file")
+	repositoryInfo, err := json.Marshal(w.repositories)
+	if err != nil {
+		return fmt.Errorf("marshal repositories error: %+v", err)
+	}
+	tools.WriteFile(fmt.Sprintf("%s/repositories", w.dir), repositoryInfo)
+
+	if w.delta != nil {
+		logrus.Debugf("create %s", archive.DeltaFile)
+		deltaBytes, err := json.Marshal(w.delta)
+		if err != nil {
+			return fmt.Errorf("marshal %s error: %+v", archive.DeltaFile, err)
+		}
+		tools.WriteFile(filepath.Join(w.dir, archive.DeltaFile), deltaBytes)
+	}
+
+	err = w.artifacts.close()
+	if err != nil {
+		return err
+	}
+
+	logrus.Debugf("tar %s -> %s", w.dir, output)
+	tools.TarDir(w.dir, output)
+
+	logrus.Debugf("remove tmp dir")
+	err = tools.RemovePath(w.dir)
+	if err != nil {
+		return fmt.Errorf("remove %s error: %+v", w.dir, err)
+	}
+	return nil
+}
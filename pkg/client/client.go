@@ -2,11 +2,11 @@ package client
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/json"
 	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
 	"github.com/DockerContainerService/image-save/pkg/tools"
 	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/blobinfocache/none"
 	"github.com/containers/image/v5/types"
@@ -17,6 +17,7 @@ import (
 	"github.com/tidwall/gjson"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -30,7 +31,11 @@ type Client struct {
 	ctx        context.Context
 	sysContext *types.SystemContext
 
-	repo *repoUrl
+	repo   *repoUrl
+	policy *Policy
+
+	console  io.Writer
+	progress bool
 }
 
 func NewClient(sourceUrl, username, password, mirror string, insecure bool) (*Client, error) {
@@ -49,11 +54,15 @@ func NewClient(sourceUrl, username, password, mirror string, insecure bool) (*Cl
 	repo.password = password
 	repo.insecure = insecure
 
-	return &Client{repo: repo}, nil
+	return &Client{repo: repo, console: os.Stdout, progress: true}, nil
 }
 
-func (c *Client) initClient() error {
-	srcRef, err := docker.ParseReference(fmt.Sprintf("//%s/%s:%s", c.repo.registry, strings.Join([]string{c.repo.namespace, c.repo.project}, "/"), c.repo.tag))
+func (c *Client) initReference() error {
+	refString := fmt.Sprintf("//%s/%s:%s", c.repo.registry, strings.Join([]string{c.repo.namespace, c.repo.project}, "/"), c.repo.tag)
+	if c.repo.digest != "" {
+		refString = fmt.Sprintf("//%s/%s@%s", c.repo.registry, strings.Join([]string{c.repo.namespace, c.repo.project}, "/"), c.repo.digest)
+	}
+	srcRef, err := docker.ParseReference(refString)
 	if err != nil {
 		return err
 	}
@@ -74,18 +83,35 @@ func (c *Client) initClient() error {
 		}
 	}
 
-	source, err := srcRef.NewImageSource(ctx, sysContext)
+	c.sourceRef = srcRef
+	c.ctx = ctx
+	c.sysContext = sysContext
+	return nil
+}
+
+func (c *Client) initClient() error {
+	if c.repo.defaultTag() {
+		c.printf("Using default tag: latest\n")
+	}
+	err := c.initReference()
+	if err != nil {
+		return err
+	}
+
+	source, err := c.sourceRef.NewImageSource(c.ctx, c.sysContext)
 	if err != nil {
 		return fmt.Errorf("get image source error: %+v", err)
 	}
 
-	c.sourceRef = srcRef
 	c.source = source
-	c.ctx = ctx
-	c.sysContext = sysContext
 	return nil
 }
 
+// WithTag returns a copy of the client pointing to another tag of the same repository
+func (c *Client) WithTag(tag string) *Client {
+	return &Client{repo: c.repo.withTag(tag), policy: c.policy, console: c.console, progress: c.progress}
+}
+
 type ManifestInfo struct {
 	Obj    manifest.Manifest
 	Digest *digest.Digest
@@ -165,7 +191,7 @@ func (c *Client) manifestHandler(manifestBytes []byte, manifestType string, osFi
 			}
 
 			subManifest, _, _, err := c.manifestHandler(mfstBytes, mfstType,
-				archFilterList, osFilterList, manifestSchemaListObj)
+				osFilterList, archFilterList, manifestSchemaListObj)
 			if err != nil {
 				return nil, nil, nil, err
 			}
@@ -218,9 +244,9 @@ func (c *Client) manifestHandler(manifestBytes []byte, manifestType string, osFi
 			}
 
 			subManifest, _, _, innerErr := c.manifestHandler(mfstBytes, mfstType,
-				archFilterList, osFilterList, nil)
+				osFilterList, archFilterList, nil)
 			if innerErr != nil {
-				return nil, nil, nil, err
+				return nil, nil, nil, innerErr
 			}
 
 			if subManifest != nil {
@@ -336,90 +362,141 @@ func (c *Client) manifestHandler(manifestBytes []byte, manifestType string, osFi
 //	return nil, nil, fmt.Errorf("unsupported manifest type: %v", manifestType)
 //}
 
-func (c *Client) Save(osFilterList, archFilterList []string, output string) error {
-	err := c.initClient()
-	if err != nil {
-		return err
-	}
-	fmt.Printf("Using architecture: %s\n", strings.Join(archFilterList, ","))
-	manifestBytes, manifestType, err := c.source.GetManifest(c.ctx, nil)
+// resolveManifest selects the single platform specific manifest matching the filters
+func (c *Client) resolveManifest(manifestBytes []byte, manifestType string, osFilterList, archFilterList []string) (*ManifestInfo, error) {
+	manifestObj, _, manifestInfoList, err := c.manifestHandler(manifestBytes, manifestType, osFilterList, archFilterList, nil)
 	if err != nil {
-		return fmt.Errorf("get manifest error: %+v", err)
+		return nil, err
 	}
-	_, _, manifestInfoList, err := c.manifestHandler(manifestBytes, manifestType, osFilterList, archFilterList, nil)
-	if err != nil {
-		return err
+
+	// a single manifest is returned as is, wrap it like the entries of a list
+	if manifestInfoList == nil && manifestObj != nil && !manifest.MIMETypeIsMultiImage(manifestType) {
+		manifestDigest, err := manifest.Digest(manifestBytes)
+		if err != nil {
+			return nil, fmt.Errorf("compute manifest digest error: %+v", err)
+		}
+		manifestInfoList = []*ManifestInfo{{Obj: manifestObj.(manifest.Manifest), Digest: &manifestDigest, Bytes: manifestBytes}}
 	}
+
 	if len(manifestInfoList) == 0 {
-		return fmt.Errorf("%s: mismatch of os[%s] or architecture[%s]", c.repo.url, strings.Join(osFilterList, ","), strings.Join(archFilterList, ","))
+		return nil, fmt.Errorf("%s: mismatch of os[%s] or architecture[%s]", c.repo.url, strings.Join(osFilterList, ","), strings.Join(archFilterList, ","))
 	} else if len(manifestInfoList) > 1 {
-		return fmt.Errorf("%s: matched of os[%s] and architecture[%s] greater than 1", c.repo.url, strings.Join(osFilterList, ","), strings.Join(archFilterList, ","))
+		return nil, fmt.Errorf("%s: matched of os[%s] and architecture[%s] greater than 1", c.repo.url, strings.Join(osFilterList, ","), strings.Join(archFilterList, ","))
+	}
+	return manifestInfoList[0], nil
+}
+
+// baseName is the name of the files written for the image when no output is given, e.g. alpine_latest
+func (c *Client) baseName() string {
+	name := strings.ReplaceAll(c.repo.url, "/", "_")
+	if strings.Contains(name, ":") {
+		return strings.ReplaceAll(name, ":", "_")
 	}
+	return name + "_latest"
+}
+
+// ArchiveName is the default name of the archive written by Save
+func (c *Client) ArchiveName() string {
+	return fmt.Sprintf("%s.tgz", c.baseName())
+}
+
+func (c *Client) Save(osFilterList, archFilterList []string, output string, opts *SaveOptions) (*Report, error) {
+	// 目录准备
+	destDir := c.baseName()
 
-	configInfo := manifestInfoList[0].Obj.ConfigInfo()
-	blob, size, err := c.source.GetBlob(c.ctx, types.BlobInfo{Digest: configInfo.Digest, URLs: configInfo.URLs, Size: configInfo.Size}, none.NoCache)
+	if output == "" {
+		output = c.ArchiveName()
+	}
+
+	w, err := newArchiveWriter(destDir)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.saveImage(w, osFilterList, archFilterList, opts)
 	if err != nil {
-		return fmt.Errorf("load config info error: %+v", err)
+		tools.RemovePath(destDir)
+		return nil, err
 	}
-	configRes, err := io.ReadAll(blob)
+	err = w.close(output)
 	if err != nil {
-		return fmt.Errorf("load config blob error: %+v", err)
+		tools.RemovePath(destDir)
+		return nil, err
 	}
 
-	// 开始导出
-	// 目录准备
-	destDir := strings.ReplaceAll(c.repo.url, "/", "_")
-	if strings.Contains(destDir, ":") {
-		destDir = strings.ReplaceAll(destDir, ":", "_")
-	} else {
-		destDir += "_latest"
+	c.printf("Output file: %s\n", output)
+	size, volumes, err := c.splitArchive(output, opts)
+	if err != nil {
+		return nil, err
 	}
+	return newReport(output, size, []*ImageResult{res}, volumes), nil
+}
+
+// SaveTags saves several tags of the repository into a single archive
+func (c *Client) SaveTags(tags []string, osFilterList, archFilterList []string, output string, opts *SaveOptions) (*Report, error) {
+	destDir := strings.ReplaceAll(strings.TrimSuffix(c.repo.url, ":"+c.repo.tag), "/", "_")
+	destDir = strings.ReplaceAll(destDir, ":", "_") + "_tags"
 
 	if output == "" {
 		output = fmt.Sprintf("%s.tgz", destDir)
 	}
 
-	if tools.IsPathExist(destDir) {
-		err = tools.RemovePath(destDir)
+	w, err := newArchiveWriter(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*ImageResult
+	for _, tag := range tags {
+		c.printf("Saving tag: %s\n", tag)
+		res, err := c.WithTag(tag).saveImage(w, osFilterList, archFilterList, opts)
 		if err != nil {
-			return fmt.Errorf("target dir already exists: %s", destDir)
+			tools.RemovePath(destDir)
+			return nil, err
 		}
+		results = append(results, res)
 	}
-	tools.MkdirPath(destDir)
-
-	// 开始写文件
-	tools.WriteFile(fmt.Sprintf("%s/%s.json", destDir, configInfo.Digest[7:]), configRes)
 
-	type manifestBody struct {
-		Config   string   `json:"Config"`
-		RepoTags []string `json:"RepoTags"`
-		Layers   []string `json:"Layers"`
+	err = w.close(output)
+	if err != nil {
+		tools.RemovePath(destDir)
+		return nil, err
 	}
 
-	manifestJson := []manifestBody{
-		{
-			Config:   fmt.Sprintf("%s.json", configInfo.Digest[7:]),
-			RepoTags: []string{fmt.Sprintf("%s", c.repo.url)},
-			Layers:   make([]string, 0),
-		},
+	c.printf("Output file: %s\n", output)
+	size, volumes, err := c.splitArchive(output, opts)
+	if err != nil {
+		return nil, err
 	}
+	return newReport(output, size, results, volumes), nil
+}
 
-	if !strings.Contains(c.repo.url, ":") {
-		manifestJson[0].RepoTags = []string{fmt.Sprintf("%s:%s", c.repo.url, c.repo.tag)}
+// splitArchive splits the archive written to output into volumes when the options ask for it,
+// it returns the size of the archive and its volumes, nil if not split
+func (c *Client) splitArchive(output string, opts *SaveOptions) (int64, *archive.Volumes, error) {
+	fi, err := os.Stat(output)
+	if err != nil {
+		return 0, nil, fmt.Errorf("stat %s error: %+v", output, err)
 	}
+	if opts.splitSize() <= 0 {
+		return fi.Size(), nil, nil
+	}
+	volumes, err := archive.SplitVolumes(output, opts.splitSize())
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, part := range volumes.Parts {
+		c.printf("Volume: %s\n", filepath.Join(filepath.Dir(output), part.Name))
+	}
+	return fi.Size(), volumes, nil
+}
 
-	emptyJson := `{"created":"1970-01-01T00:00:00Z","container_config":{"Hostname":"","Domainname":"","User":"","AttachStdin":false,
-	"AttachStdout":false,"AttachStderr":false,"Tty":false,"OpenStdin":false, "StdinOnce":false,"Env":null,"Cmd":null,"Image":"",
-	"Volumes":null,"WorkingDir":"","Entrypoint":null,"OnBuild":null,"Labels":null}}`
-
-	parentId := ""
-	var layerDirId string
-
+func (c *Client) newProgressWriter(trackers int) progress.Writer {
 	pw := progress.NewWriter()
+	pw.SetOutputWriter(c.console)
 	pw.SetAutoStop(true)
 	pw.SetTrackerLength(25)
 	pw.SetMessageWidth(15)
-	pw.SetNumTrackersExpected(len(manifestInfoList[0].Obj.LayerInfos()))
+	pw.SetNumTrackersExpected(trackers)
 	pw.SetSortBy(progress.SortByPercentDsc)
 	pw.SetStyle(progress.StyleDefault)
 	pw.SetTrackerPosition(progress.PositionRight)
@@ -432,93 +509,272 @@ func (c *Client) Save(osFilterList, archFilterList []string, output string) erro
 	pw.Style().Visibility.SpeedOverall = false
 	pw.Style().Visibility.TrackerOverall = false
 	pw.Style().Visibility.Pinned = false
+	return pw
+}
+
+// saveImage downloads the image matching the filters into the staging dir of the archive
+func (c *Client) saveImage(w *archiveWriter, osFilterList, archFilterList []string, opts *SaveOptions) (*ImageResult, error) {
+	if opts == nil {
+		opts = &SaveOptions{}
+	}
+	if err := validateForeignLayers(opts.ForeignLayers); err != nil {
+		return nil, err
+	}
+	if opts.Squash && opts.Baseline != nil {
+		return nil, fmt.Errorf("a squashed image cannot be saved against a baseline")
+	}
+	policyKey, err := c.checkPolicy()
+	if err != nil {
+		return nil, err
+	}
+	repoTags, err := c.repo.repoTags(opts.TagAs, opts.Tags, opts.NoTags)
+	if err != nil {
+		return nil, err
+	}
+	for _, tagged := range repoTags {
+		if w.tagged(reference.FamiliarName(tagged), tagged.Tag()) {
+			return nil, fmt.Errorf("%s already tags another image of the archive", reference.FamiliarString(tagged))
+		}
+	}
+
+	err = c.initClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.source.Close()
 
-	var wg sync.WaitGroup
-	wg.Add(len(manifestInfoList[0].Obj.LayerInfos()))
+	c.printf("Using architecture: %s\n", strings.Join(archFilterList, ","))
+	manifestBytes, manifestType, err := c.source.GetManifest(c.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get manifest error: %+v", err)
+	}
+	manifestInfo, err := c.resolveManifest(manifestBytes, manifestType, osFilterList, archFilterList)
+	if err != nil {
+		return nil, err
+	}
+	topDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return nil, fmt.Errorf("compute manifest digest error: %+v", err)
+	}
+	digests := []digest.Digest{topDigest}
+	if *manifestInfo.Digest != topDigest {
+		digests = append(digests, *manifestInfo.Digest)
+	}
 
-	go pw.Render()
+	// check the signatures before downloading anything else
+	for _, key := range []*PublicKey{opts.VerifyKey, policyKey} {
+		if key == nil {
+			continue
+		}
+		signed, err := c.verifySignature(key, digests)
+		if err != nil {
+			return nil, err
+		}
+		c.printf("Verified signature of %s\n", signed)
+	}
 
-	for _, layer := range manifestInfoList[0].Obj.LayerInfos() {
-		layerDigest := layer.Digest
-		logrus.Debugf("Digest: %s", layerDigest)
-		layerDirId = fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%s%s", parentId, layerDigest))))
-		layerDir := fmt.Sprintf("%s/%s", destDir, layerDirId)
-		tools.MkdirPath(layerDir)
+	// schema1 manifests have no config blob, it is synthesized from the history once the layers are downloaded
+	schema1, isSchema1 := manifestInfo.Obj.(*manifest.Schema1)
+	var configRes []byte
+	var diffIDs []digest.Digest
+	if !isSchema1 {
+		configInfo := manifestInfo.Obj.ConfigInfo()
+		blob, _, err := c.source.GetBlob(c.ctx, types.BlobInfo{Digest: configInfo.Digest, URLs: configInfo.URLs, Size: configInfo.Size}, none.NoCache)
+		if err != nil {
+			return nil, fmt.Errorf("load config info error: %+v", err)
+		}
+		configRes, err = io.ReadAll(blob)
+		blob.Close()
+		if err != nil {
+			return nil, fmt.Errorf("load config blob error: %+v", err)
+		}
+		// the signature only covers the manifest, the blobs are trusted through their digests
+		if err = configInfo.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid config digest %s: %+v", configInfo.Digest, err)
+		}
+		if actual := configInfo.Digest.Algorithm().FromBytes(configRes); actual != configInfo.Digest {
+			return nil, fmt.Errorf("config blob %s does not match the digest %s of the manifest", actual, configInfo.Digest)
+		}
+		for _, diffID := range gjson.GetBytes(configRes, "rootfs.diff_ids").Array() {
+			diffIDs = append(diffIDs, digest.Digest(diffID.String()))
+		}
+		if layers := len(manifestInfo.Obj.LayerInfos()); len(diffIDs) != layers {
+			return nil, fmt.Errorf("config has %d diff_ids but the manifest has %d layers", len(diffIDs), layers)
+		}
+	}
 
-		logrus.Debugf("create Version file")
-		tools.WriteFile(fmt.Sprintf("%s/VERSION", layerDir), []byte("1.0"))
+	// 开始写文件
+	destDir := w.dir
 
-		logrus.Debugf("create layer.tar")
-		blob, size, err = c.source.GetBlob(c.ctx, types.BlobInfo{Digest: layerDigest, URLs: layer.URLs, Size: layer.Size}, none.NoCache)
-		tracker := progress.Tracker{
-			Message: fmt.Sprintf("[%s]", string(layerDigest[7:19])),
-			Total:   size,
-			Units:   progress.UnitsBytes,
+	manifestJson := manifestBody{
+		RepoTags: make([]string, 0),
+		Layers:   make([]string, 0),
+	}
+	for _, tagged := range repoTags {
+		manifestJson.RepoTags = append(manifestJson.RepoTags, reference.FamiliarString(tagged))
+	}
+
+	res := &ImageResult{
+		Reference:      c.sourceRef.DockerReference().String(),
+		Tag:            c.repo.tag,
+		Registry:       c.repo.registry,
+		Digest:         topDigest,
+		PlatformDigest: *manifestInfo.Digest,
+		RepoTags:       manifestJson.RepoTags,
+	}
+
+	if opts.Artifacts {
+		res.Artifacts, err = c.saveArtifacts(w.artifacts, digests)
+		if err != nil {
+			return nil, err
 		}
+		c.printf("Artifacts found: %d\n", res.Artifacts)
+	}
 
-		pw.AppendTracker(&tracker)
+	var layers []manifest.LayerInfo
+	for _, layer := range manifestInfo.Obj.LayerInfos() {
+		if isSchema1 && layer.EmptyLayer {
+			// throwaway entries only carry history, like docker pull does not download them
+			continue
+		}
+		layers = append(layers, layer)
+	}
 
-		go func() {
-			defer wg.Done()
-			tools.WriteBufferedFile(fmt.Sprintf("%s/layer.tar", layerDir), blob, size, &tracker)
-		}()
+	var mu sync.Mutex
+	var downloads []blobDownload
 
-		manifestJson[0].Layers = append(manifestJson[0].Layers, fmt.Sprintf("%s/layer.tar", layerDirId))
+	// the layer ids depend on the diff_ids, the blobs are downloaded first and moved into their layer dir afterwards
+	for n, layer := range layers {
+		layerDigest := layer.Digest
+		logrus.Debugf("Digest: %s", layerDigest)
+		res.Layers = append(res.Layers, LayerResult{Digest: layerDigest, MediaType: layer.MediaType, Size: layer.Size})
 
-		logrus.Debugf("create json file")
-		jsonObj := make(map[string]interface{})
-		if manifestInfoList[0].Obj.LayerInfos()[len(manifestInfoList[0].Obj.LayerInfos())-1].Digest == layerDigest {
-			err = json.Unmarshal(configRes, &jsonObj)
-			if err != nil {
-				return fmt.Errorf("create json file error-1: %+v", err)
-			}
-			delete(jsonObj, "history")
-			delete(jsonObj, "rootfs")
-		} else {
-			err = json.Unmarshal([]byte(emptyJson), &jsonObj)
-			if err != nil {
-				return fmt.Errorf("create json file error-2: %+v", err)
+		if opts.ForeignLayers == ForeignLayersSkip && isForeignLayer(layer) {
+			res.Layers[n].Foreign = true
+			c.printf("[%s]  ... skipped foreign layer\n", string(layerDigest[7:19]))
+			continue
+		}
+		// the diff_ids of schema1 images are only known once downloaded
+		if opts.Baseline != nil && !isSchema1 && opts.Baseline.Has(layerDigest, diffIDs[n]) {
+			res.Layers[n].External = true
+			c.printf("[%s]  ... already in %s\n", string(layerDigest[7:19]), opts.Baseline.Name)
+			continue
+		}
+		blobFile, downloaded := w.addBlob(layerDigest)
+		if downloaded {
+			logrus.Debugf("blob %s already downloaded", layerDigest)
+			continue
+		}
+
+		logrus.Debugf("download %s", blobFile)
+		d := blobDownload{layer: layer, file: filepath.Join(destDir, blobFile)}
+		if isSchema1 {
+			d.done = func(file string) error {
+				diffID, err := fileDiffID(file)
+				if err != nil {
+					return fmt.Errorf("compute diff_id of %s error: %+v", layerDigest, err)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				w.diffIDs[layerDigest] = diffID
+				return nil
 			}
 		}
-		jsonObj["id"] = layerDirId
-		if parentId != "" {
-			jsonObj["parent"] = parentId
+		downloads = append(downloads, d)
+	}
+	if err = c.downloadBlobs(downloads); err != nil {
+		return nil, err
+	}
+
+	if isSchema1 {
+		// blobs downloaded by a previous image of the archive were hashed at that time
+		diffIDs = make([]digest.Digest, 0, len(layers))
+		for _, layer := range layers {
+			diffIDs = append(diffIDs, w.diffIDs[layer.Digest])
+		}
+		configRes, err = schema1.ToSchema2Config(diffIDs)
+		if err != nil {
+			return nil, fmt.Errorf("convert schema1 manifest error: %+v", err)
 		}
-		parentId = layerDirId
-		jsonObjByte, err := json.Marshal(jsonObj)
+	}
+	if opts.Squash {
+		var squashed manifest.LayerInfo
+		configRes, squashed, err = w.squash(configRes, layers)
 		if err != nil {
-			return fmt.Errorf("create json file error-3: %+v", err)
+			return nil, err
 		}
-		tools.WriteFile(fmt.Sprintf("%s/json", layerDir), jsonObjByte)
+		c.printf("Squashed %d layers into %s\n", len(layers), squashed.Digest)
+		layers = []manifest.LayerInfo{squashed}
+		diffIDs = []digest.Digest{squashed.Digest}
+		res.Layers = []LayerResult{{Digest: squashed.Digest, MediaType: squashed.MediaType, Size: squashed.Size}}
 	}
-	time.Sleep(time.Second)
 
-	wg.Wait()
+	configDigest := digest.FromBytes(configRes)
+	tools.WriteFile(fmt.Sprintf("%s/%s.json", destDir, configDigest.Encoded()), configRes)
+	manifestJson.Config = fmt.Sprintf("%s.json", configDigest.Encoded())
 
-	for pw.IsRenderInProgress() {
-		time.Sleep(time.Millisecond * 100)
-	}
+	platform := gjson.GetManyBytes(configRes, "os", "architecture", "variant")
+	res.Platform = strings.TrimSuffix(fmt.Sprintf("%s/%s/%s", platform[0].String(), platform[1].String(), platform[2].String()), "/")
 
-	logrus.Debugf("create manifest.json")
-	manifestByte, err := json.Marshal(manifestJson)
+	v1Layers, err := newV1Layers(configRes, diffIDs)
 	if err != nil {
-		return fmt.Errorf("marshal manifestJson error: %+v", err)
+		return nil, err
 	}
-	tools.WriteFile(fmt.Sprintf("%s/manifest.json", destDir), manifestByte)
 
-	logrus.Debugf("create repositories file")
-	repositoryInfo := fmt.Sprintf("{\"%s\":{\"%s\":\"%x\"}}", c.repo.url, c.repo.tag, layerDirId)
-	tools.WriteFile(fmt.Sprintf("%s/repositories", destDir), []byte(repositoryInfo))
+	var layerDirId string
+	for n, v1Layer := range v1Layers {
+		layerDirId = v1Layer.id.Encoded()
+		manifestJson.Layers = append(manifestJson.Layers, fmt.Sprintf("%s/layer.tar", layerDirId))
+		if res.Layers[n].Foreign {
+			if manifestJson.LayerSources == nil {
+				manifestJson.LayerSources = make(map[digest.Digest]specsv1.Descriptor)
+			}
+			manifestJson.LayerSources[diffIDs[n]] = specsv1.Descriptor{
+				MediaType: layers[n].MediaType,
+				Digest:    layers[n].Digest,
+				Size:      layers[n].Size,
+				URLs:      layers[n].URLs,
+			}
+		}
 
-	logrus.Debugf("tar %s -> %s", destDir, output)
-	tools.TarDir(destDir, output)
+		if !w.addLayer(layerDirId) {
+			logrus.Debugf("layer %s already exists in archive", layerDirId)
+			res.SharedLayers++
+			continue
+		}
 
-	logrus.Debugf("remove tmp dir")
-	err = tools.RemovePath(destDir)
-	if err != nil {
-		return fmt.Errorf("remove %s error: %+v", destDir, err)
+		layerDir := fmt.Sprintf("%s/%s", destDir, layerDirId)
+		tools.MkdirPath(layerDir)
+
+		logrus.Debugf("create Version file")
+		tools.WriteFile(fmt.Sprintf("%s/VERSION", layerDir), []byte("1.0"))
+
+		logrus.Debugf("create json file")
+		tools.WriteFile(fmt.Sprintf("%s/json", layerDir), v1Layer.json)
+
+		if res.Layers[n].External {
+			w.addExternal(opts.Baseline.Name, fmt.Sprintf("%s/layer.tar", layerDirId), archive.DeltaLayer{Digest: layers[n].Digest, DiffID: diffIDs[n]})
+		} else if !res.Layers[n].Foreign {
+			err = w.placeBlob(layers[n].Digest, fmt.Sprintf("%s/layer.tar", layerDirId))
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	fmt.Printf("Output file: %s\n", output)
-	return nil
+	w.addManifest(manifestJson)
+	for _, tagged := range repoTags {
+		w.addRepository(reference.FamiliarName(tagged), tagged.Tag(), layerDirId)
+	}
+	return res, nil
+}
+
+// fileDiffID returns the digest of the uncompressed content of a layer file
+func fileDiffID(filename string) (digest.Digest, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return archive.DiffID(f)
 }
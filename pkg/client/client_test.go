@@ -0,0 +1,488 @@
+package client
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chdirTemp runs the test in an empty working directory, Save stages the archive in the working directory
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func newTestRegistry(t *testing.T) *registrytest.Registry {
+	t.Helper()
+	reg := registrytest.New()
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+func newTestClient(t *testing.T, reg *registrytest.Registry, ref, username, password string) *Client {
+	t.Helper()
+	c, err := NewClient(fmt.Sprintf("%s/%s", reg.Host(), ref), username, password, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func openArchive(t *testing.T, path string) *archive.Archive {
+	t.Helper()
+	a, err := archive.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func configOf(t *testing.T, a *archive.Archive, i int) map[string]interface{} {
+	t.Helper()
+	var config map[string]interface{}
+	if err := json.Unmarshal(a.Configs[a.Manifest[i].Config], &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// verifyArchive fails on any problem of the archive
+func verifyArchive(t *testing.T, a *archive.Archive) {
+	t.Helper()
+	problems, err := a.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Errorf("%s", problem)
+	}
+}
+
+func TestSaveSchema2(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64",
+		registrytest.FileLayer(map[string]string{"etc/os-release": "test"}),
+		registrytest.FileLayer(map[string]string{"app/run.sh": "echo hello"}))
+	reg.PushImage("library/app", "1.0", img)
+
+	c := newTestClient(t, reg, "library/app:1.0", "", "")
+	output := filepath.Join(dir, "app.tgz")
+	report, err := c.Save(nil, []string{"amd64"}, output, nil)
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+
+	if len(report.Images) != 1 || report.Images[0].PlatformDigest != img.Digest() || report.Images[0].Digest != img.Digest() {
+		t.Errorf("unexpected report: %+v", report.Images[0])
+	}
+	if report.Images[0].Platform != "linux/amd64" || len(report.Images[0].Layers) != 2 {
+		t.Errorf("unexpected report: %+v", report.Images[0])
+	}
+
+	a := openArchive(t, output)
+	if len(a.Manifest) != 1 || len(a.Manifest[0].Layers) != 2 {
+		t.Fatalf("unexpected manifest.json: %+v", a.Manifest)
+	}
+	if string(a.Configs[a.Manifest[0].Config]) != string(img.Config) {
+		t.Errorf("config was not saved as is")
+	}
+	verifyArchive(t, a)
+	if tags := a.Manifest[0].RepoTags; len(tags) != 1 || tags[0] != reg.Host()+"/library/app:1.0" {
+		t.Errorf("unexpected RepoTags: %v", tags)
+	}
+	top := strings.TrimSuffix(a.Manifest[0].Layers[1], "/layer.tar")
+	if id := a.Repositories[reg.Host()+"/library/app"]["1.0"]; id != top {
+		t.Errorf("repositories points to %s instead of %s", id, top)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("staging dir was not removed: %v", entries)
+	}
+}
+
+func TestSaveTagAs(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"a": "b"})))
+
+	output := filepath.Join(dir, "app.tgz")
+	report, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output,
+		&SaveOptions{TagAs: []string{"app:stable", "registry.local/team/app"}})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if strings.Join(report.Images[0].RepoTags, ",") != "app:stable,registry.local/team/app:1.0" {
+		t.Errorf("unexpected RepoTags: %v", report.Images[0].RepoTags)
+	}
+	a := openArchive(t, output)
+	verifyArchive(t, a)
+	if len(a.Repositories) != 2 || a.Repositories["app"]["stable"] == "" || a.Repositories["registry.local/team/app"]["1.0"] == "" {
+		t.Errorf("unexpected repositories: %v", a.Repositories)
+	}
+
+	_, err = newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output, &SaveOptions{NoTags: true})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	a = openArchive(t, output)
+	if len(a.Manifest[0].RepoTags) != 0 || len(a.Repositories) != 0 {
+		t.Errorf("image is tagged: %+v %v", a.Manifest[0], a.Repositories)
+	}
+}
+
+func TestSaveSelectsPlatformFromList(t *testing.T) {
+	for _, oci := range []bool{false, true} {
+		t.Run(fmt.Sprintf("oci=%v", oci), func(t *testing.T) {
+			dir := chdirTemp(t)
+			reg := newTestRegistry(t)
+			newImage := registrytest.NewImage
+			if oci {
+				newImage = registrytest.NewOCIImage
+			}
+			amd64 := newImage("linux/amd64", registrytest.FileLayer(map[string]string{"arch": "amd64"}))
+			arm64 := newImage("linux/arm64/v8", registrytest.FileLayer(map[string]string{"arch": "arm64"}))
+			index := reg.PushIndex("library/app", "1.0", amd64, arm64)
+
+			c := newTestClient(t, reg, "library/app:1.0", "", "")
+			report, err := c.Save(nil, []string{"arm64"}, filepath.Join(dir, "app.tgz"), nil)
+			if err != nil {
+				t.Fatalf("save error: %+v", err)
+			}
+			if report.Images[0].Digest != index || report.Images[0].PlatformDigest != arm64.Digest() {
+				t.Errorf("unexpected report: %+v", report.Images[0])
+			}
+
+			a := openArchive(t, filepath.Join(dir, "app.tgz"))
+			if arch := configOf(t, a, 0)["architecture"]; arch != "arm64" {
+				t.Errorf("saved architecture %v", arch)
+			}
+
+			_, err = newTestClient(t, reg, "library/app:1.0", "", "").Save(nil, []string{"s390x"}, filepath.Join(dir, "none.tgz"), nil)
+			if err == nil || !strings.Contains(err.Error(), "mismatch") {
+				t.Errorf("expected a mismatch error, got %+v", err)
+			}
+		})
+	}
+}
+
+func TestSaveTokenAuth(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.EnableTokenAuth("user", "secret")
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"a": "b"})))
+
+	_, err := newTestClient(t, reg, "team/app:1.0", "user", "secret").Save(nil, []string{"amd64"}, filepath.Join(dir, "app.tgz"), nil)
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+
+	_, err = newTestClient(t, reg, "team/app:1.0", "user", "wrong").Save(nil, []string{"amd64"}, filepath.Join(dir, "denied.tgz"), nil)
+	if err == nil {
+		t.Errorf("expected an authentication error")
+	}
+}
+
+func TestSaveRegistryFaults(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"a": "b"}))
+	reg.PushImage("team/app", "1.0", img)
+
+	reg.InjectFault("/manifests/", http.StatusInternalServerError, 1)
+	_, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, filepath.Join(dir, "app.tgz"), nil)
+	if err == nil {
+		t.Fatalf("expected an error on internal server error")
+	}
+
+	// rate limited requests are retried
+	reg.InjectFault("/manifests/", http.StatusTooManyRequests, 1)
+	_, err = newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, filepath.Join(dir, "app.tgz"), nil)
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+}
+
+func TestSaveTags(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PageSize = 2
+	base := registrytest.FileLayer(map[string]string{"etc/os-release": "test"})
+	for _, tag := range []string{"1.1.0", "1.2.0", "1.2.1", "2.0.0", "latest"} {
+		reg.PushImage("team/app", tag, registrytest.NewImage("linux/amd64", base, registrytest.FileLayer(map[string]string{"version": tag})))
+	}
+
+	c := newTestClient(t, reg, "team/app", "", "")
+	tags, err := c.ListTags()
+	if err != nil {
+		t.Fatalf("list tags error: %+v", err)
+	}
+	if len(tags) != 5 {
+		t.Fatalf("pagination was not followed: %v", tags)
+	}
+
+	tags, err = (&TagFilter{Constraint: "~1.2", Sort: SortSemver}).Apply(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tags, ",") != "1.2.1,1.2.0" {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	output := filepath.Join(dir, "app.tgz")
+	report, err := c.SaveTags(tags, nil, []string{"amd64"}, output, nil)
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if len(report.Images) != 2 || report.Images[1].SharedLayers != 1 {
+		t.Errorf("base layer was not shared: %+v", report.Images)
+	}
+
+	a := openArchive(t, output)
+	if len(a.Manifest) != 2 {
+		t.Fatalf("unexpected manifest.json: %+v", a.Manifest)
+	}
+	if a.Manifest[0].Layers[0] != a.Manifest[1].Layers[0] {
+		t.Errorf("base layer is not shared: %+v", a.Manifest)
+	}
+}
+
+func TestSaveForeignLayers(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	base := registrytest.FileLayer(map[string]string{"Windows/System32/kernel32.dll": "base"})
+	img := registrytest.NewImage("windows/amd64", base, registrytest.FileLayer(map[string]string{"app.exe": "app"}))
+
+	// mark the base layer as foreign and keep it out of the registry
+	var m map[string]interface{}
+	json.Unmarshal(img.Manifest, &m)
+	layer := m["layers"].([]interface{})[0].(map[string]interface{})
+	layer["mediaType"] = manifest.DockerV2Schema2ForeignLayerMediaTypeGzip
+	layer["urls"] = []string{"https://example.invalid/base.tar.gz"}
+	img.Manifest, _ = json.Marshal(m)
+	img.Layers = img.Layers[1:]
+	reg.PushImage("windows/app", "1.0", img)
+
+	output := filepath.Join(dir, "app.tgz")
+	report, err := newTestClient(t, reg, "windows/app:1.0", "", "").Save([]string{"windows"}, []string{"amd64"}, output, &SaveOptions{ForeignLayers: ForeignLayersSkip})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if !report.Images[0].Layers[0].Foreign || report.Images[0].Layers[1].Foreign {
+		t.Errorf("unexpected report: %+v", report.Images[0].Layers)
+	}
+
+	a := openArchive(t, output)
+	diffIDs := configOf(t, a, 0)["rootfs"].(map[string]interface{})["diff_ids"].([]interface{})
+	source, ok := a.Manifest[0].LayerSources[digest.Digest(diffIDs[0].(string))]
+	if !ok || len(a.Manifest[0].LayerSources) != 1 || source.URLs[0] != "https://example.invalid/base.tar.gz" {
+		t.Errorf("unexpected layer sources: %+v", a.Manifest[0].LayerSources)
+	}
+	if _, ok = a.Files[a.Manifest[0].Layers[0]]; ok {
+		t.Errorf("foreign layer was saved")
+	}
+	if _, ok = a.Files[a.Manifest[0].Layers[1]]; !ok {
+		t.Errorf("layer %s missing", a.Manifest[0].Layers[1])
+	}
+
+	_, err = newTestClient(t, reg, "windows/app:1.0", "", "").Save([]string{"windows"}, []string{"amd64"}, output, &SaveOptions{ForeignLayers: "ignore"})
+	if err == nil {
+		t.Errorf("expected an unsupported mode error")
+	}
+}
+
+func TestSaveSchema1(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	base := registrytest.FileLayer(map[string]string{"etc/os-release": "legacy"})
+	img := registrytest.NewSchema1Image("amd64", base, registrytest.FileLayer(map[string]string{"app/run.sh": "echo hello"}))
+	reg.PushImage("legacy/app", "1.0", img)
+	reg.PushImage("legacy/app", "1.1", registrytest.NewSchema1Image("amd64", base, registrytest.FileLayer(map[string]string{"app/run.sh": "echo 1.1"})))
+
+	output := filepath.Join(dir, "app.tgz")
+	report, err := newTestClient(t, reg, "legacy/app:1.0", "", "").Save(nil, []string{"amd64"}, output, nil)
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if report.Images[0].Platform != "linux/amd64" || len(report.Images[0].Layers) != 2 {
+		t.Errorf("unexpected report: %+v", report.Images[0])
+	}
+
+	a := openArchive(t, output)
+	if len(a.Manifest[0].Layers) != 2 {
+		t.Fatalf("throwaway layer was saved: %+v", a.Manifest[0])
+	}
+	config := configOf(t, a, 0)
+	if history := config["history"].([]interface{}); len(history) != 3 || history[2].(map[string]interface{})["empty_layer"] != true {
+		t.Errorf("unexpected history: %+v", history)
+	}
+	verifyArchive(t, a)
+
+	// the diff_id of the base layer shared by both tags is known without downloading it again
+	output = filepath.Join(dir, "tags.tgz")
+	report, err = newTestClient(t, reg, "legacy/app", "", "").SaveTags([]string{"1.0", "1.1"}, nil, []string{"amd64"}, output, nil)
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if report.Images[1].SharedLayers != 1 {
+		t.Errorf("base layer was not shared: %+v", report.Images[1])
+	}
+	verifyArchive(t, openArchive(t, output))
+}
+
+func TestSaveLayerHistory(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	empty := registrytest.Layer()
+	img := registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"a": "b"}), empty, empty)
+	reg.PushImage("team/app", "1.0", img)
+
+	output := filepath.Join(dir, "app.tgz")
+	_, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output, nil)
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	a := openArchive(t, output)
+	verifyArchive(t, a)
+
+	layers := a.Manifest[0].Layers
+	if len(layers) != 3 || layers[1] == layers[2] {
+		t.Fatalf("unexpected layers: %v", layers)
+	}
+	jsons := make(map[string]map[string]interface{})
+	a.Walk(func(hdr *tar.Header, r io.Reader) error {
+		if strings.HasSuffix(hdr.Name, "/json") {
+			var v1 map[string]interface{}
+			json.NewDecoder(r).Decode(&v1)
+			jsons[strings.TrimSuffix(hdr.Name, "/json")] = v1
+		}
+		return nil
+	})
+
+	for i, layer := range layers {
+		id := strings.TrimSuffix(layer, "/layer.tar")
+		v1 := jsons[id]
+		if v1["id"] != id {
+			t.Errorf("layer %d has id %v in dir %s", i, v1["id"], id)
+		}
+		if i > 0 && v1["parent"] != strings.TrimSuffix(layers[i-1], "/layer.tar") {
+			t.Errorf("layer %d has parent %v", i, v1["parent"])
+		}
+		if i == len(layers)-1 {
+			if v1["config"] == nil || v1["history"] != nil {
+				t.Errorf("top layer json is not the image config: %v", v1)
+			}
+			continue
+		}
+		for key := range v1 {
+			if key != "id" && key != "parent" && key != "created" && key != "container_config" {
+				t.Errorf("layer %d json has the unexpected key %s: %v", i, key, v1)
+			}
+		}
+		cmd := v1["container_config"].(map[string]interface{})["Cmd"].([]interface{})
+		if cmd[0] != fmt.Sprintf("/bin/sh -c #(nop) ADD layer%d in /", i) || v1["created"] != fmt.Sprintf("2023-01-02T03:0%d:05Z", 4+i) {
+			t.Errorf("layer %d json does not match its history: %v", i, v1)
+		}
+	}
+}
+
+func TestNewV1LayersKeepsDiffIDs(t *testing.T) {
+	diffIDs := []digest.Digest{digest.FromString("base"), digest.FromString("app")}
+	want := append([]digest.Digest{}, diffIDs...)
+	if _, err := newV1Layers([]byte(`{"os": "linux"}`), diffIDs); err != nil {
+		t.Fatalf("build v1 layers error: %+v", err)
+	}
+	if !reflect.DeepEqual(diffIDs, want) {
+		t.Errorf("diff_ids were changed into %v", diffIDs)
+	}
+}
+
+func TestSaveTamperedBlobs(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"etc/os-release": "test"}))
+	reg.PushImage("library/config", "1.0", img)
+	reg.PushImage("library/layer", "1.0", img)
+
+	tampered := func(content []byte) []byte {
+		res := append([]byte{}, content...)
+		res[len(res)-2] ^= 0xff
+		return res
+	}
+	configDigest := digest.FromBytes(img.Config)
+	reg.ReplaceBlob("library/config", configDigest, tampered(img.Config))
+	layerDigest := digest.FromBytes(img.Layers[0])
+	reg.ReplaceBlob("library/layer", layerDigest, tampered(img.Layers[0]))
+
+	for repo, want := range map[string]digest.Digest{"library/config": configDigest, "library/layer": layerDigest} {
+		c := newTestClient(t, reg, repo+":1.0", "", "")
+		_, err := c.Save(nil, []string{"amd64"}, filepath.Join(dir, "app.tgz"), nil)
+		if err == nil || !strings.Contains(err.Error(), want.String()) || !strings.Contains(err.Error(), "match") {
+			t.Errorf("%s: expected a digest mismatch of %s, got %v", repo, want, err)
+		}
+	}
+	// neither the archive nor the staging dirs of the images are left behind
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("the working dir is not empty after the failed saves: %v %v", entries, err)
+	}
+
+	c := newTestClient(t, reg, "library/layer:1.0", "", "")
+	if _, err := c.Export(nil, []string{"amd64"}, filepath.Join(dir, "rootfs.tar")); err == nil || !strings.Contains(err.Error(), "match") {
+		t.Errorf("expected the export of a tampered layer to fail, got %v", err)
+	}
+}
+
+func TestSaveConsole(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PushImage("library/app", "latest", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"app": "v1"})))
+
+	var console strings.Builder
+	c := newTestClient(t, reg, "library/app", "", "")
+	c.SetConsole(&console, false)
+	output := filepath.Join(dir, "app.tgz")
+	if _, err := c.Save(nil, []string{"amd64"}, output, nil); err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	for _, want := range []string{"Using default tag: latest", "Using architecture: amd64", "Output file: " + output} {
+		if !strings.Contains(console.String(), want) {
+			t.Errorf("console misses %q: %q", want, console.String())
+		}
+	}
+}
+
+func TestTagFilterSelectsTags(t *testing.T) {
+	for _, test := range []struct {
+		filter TagFilter
+		want   bool
+	}{
+		{TagFilter{}, false},
+		{TagFilter{Sort: SortSemver}, false},
+		{TagFilter{Limit: 3}, false},
+		{TagFilter{Regex: "^1\\."}, true},
+		{TagFilter{Constraint: ">=1.2", Limit: 3}, true},
+	} {
+		if got := test.filter.SelectsTags(); got != test.want {
+			t.Errorf("%+v: got %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package client
+
+import (
+	"fmt"
+	"io"
+)
+
+// SetConsole sends the progress messages of the client to w instead of os.Stdout, io.Discard silences them.
+// progress renders the progress bars of the downloads on w
+func (c *Client) SetConsole(w io.Writer, progress bool) {
+	c.console, c.progress = w, progress
+}
+
+func (c *Client) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.console, format, args...)
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveDelta(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	base := registrytest.FileLayer(map[string]string{"etc/os-release": "base"})
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", base, registrytest.FileLayer(map[string]string{"app": "v1"})))
+	reg.PushImage("team/app", "2.0", registrytest.NewImage("linux/amd64", base, registrytest.FileLayer(map[string]string{"app": "v2"})))
+
+	baselineFile := filepath.Join(dir, "app-1.0.tgz")
+	if _, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, baselineFile, nil); err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	baseline, err := archive.LoadBaseline(baselineFile)
+	if err != nil {
+		t.Fatalf("load baseline error: %+v", err)
+	}
+
+	output := filepath.Join(dir, "app-2.0.tgz")
+	before := len(reg.Requests())
+	report, err := newTestClient(t, reg, "team/app:2.0", "", "").Save(nil, []string{"amd64"}, output, &SaveOptions{Baseline: baseline})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if !report.Images[0].Layers[0].External || report.Images[0].Layers[1].External {
+		t.Errorf("unexpected report: %+v", report.Images[0].Layers)
+	}
+	for _, req := range reg.Requests()[before:] {
+		if strings.Contains(req, digest.FromBytes(base).String()) {
+			t.Errorf("baseline layer downloaded: %s", req)
+		}
+	}
+
+	delta := openArchive(t, output)
+	verifyArchive(t, delta)
+	if delta.Delta == nil || delta.Delta.Baseline != "app-1.0.tgz" || len(delta.Delta.Layers) != 1 {
+		t.Fatalf("unexpected delta: %+v", delta.Delta)
+	}
+	if _, ok := delta.Files[delta.Manifest[0].Layers[0]]; ok {
+		t.Errorf("baseline layer was saved")
+	}
+
+	merged := filepath.Join(dir, "app-2.0_full.tgz")
+	if err = archive.MergeBaseline(delta, openArchive(t, baselineFile), merged); err != nil {
+		t.Fatalf("merge error: %+v", err)
+	}
+	full := openArchive(t, merged)
+	verifyArchive(t, full)
+	if full.Delta != nil {
+		t.Errorf("merged archive is still a delta one")
+	}
+	for _, layer := range full.Manifest[0].Layers {
+		if _, ok := full.Files[layer]; !ok {
+			t.Errorf("layer %s missing from the merged archive", layer)
+		}
+	}
+
+	// a list of digests works as a baseline too
+	list := filepath.Join(dir, "baseline.txt")
+	os.WriteFile(list, []byte("# shipped layers\n"+digest.FromBytes(base).String()+"\n"), 0644)
+	if baseline, err = archive.LoadBaseline(list); err != nil {
+		t.Fatalf("load baseline error: %+v", err)
+	}
+	report, err = newTestClient(t, reg, "team/app:2.0", "", "").Save(nil, []string{"amd64"}, filepath.Join(dir, "list.tgz"), &SaveOptions{Baseline: baseline})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if !report.Images[0].Layers[0].External {
+		t.Errorf("unexpected report: %+v", report.Images[0].Layers)
+	}
+}
+
+func TestSaveDeltaOfDelta(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	base := registrytest.FileLayer(map[string]string{"etc/os-release": "base"})
+	v2 := registrytest.FileLayer(map[string]string{"app": "v2"})
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", base, registrytest.FileLayer(map[string]string{"app": "v1"})))
+	reg.PushImage("team/app", "2.0", registrytest.NewImage("linux/amd64", base, v2))
+	reg.PushImage("team/app", "3.0", registrytest.NewImage("linux/amd64", base, v2, registrytest.FileLayer(map[string]string{"plugin": "v3"})))
+
+	save := func(tag, output, baselineFile string) *Report {
+		t.Helper()
+		opts := &SaveOptions{}
+		if baselineFile != "" {
+			baseline, err := archive.LoadBaseline(baselineFile)
+			if err != nil {
+				t.Fatalf("load baseline error: %+v", err)
+			}
+			opts.Baseline = baseline
+		}
+		report, err := newTestClient(t, reg, "team/app:"+tag, "", "").Save(nil, []string{"amd64"}, output, opts)
+		if err != nil {
+			t.Fatalf("save error: %+v", err)
+		}
+		return report
+	}
+	full := filepath.Join(dir, "app-1.0.tgz")
+	save("1.0", full, "")
+	delta := filepath.Join(dir, "app-2.0.tgz")
+	save("2.0", delta, full)
+
+	// the base layer is not in the delta archive used as baseline, it is saved again
+	output := filepath.Join(dir, "app-3.0.tgz")
+	layers := save("3.0", output, delta).Images[0].Layers
+	if layers[0].External || !layers[1].External || layers[2].External {
+		t.Errorf("unexpected report: %+v", layers)
+	}
+
+	merged := filepath.Join(dir, "app-3.0_full.tgz")
+	if err := archive.MergeBaseline(openArchive(t, output), openArchive(t, delta), merged); err != nil {
+		t.Fatalf("merge error: %+v", err)
+	}
+	a := openArchive(t, merged)
+	verifyArchive(t, a)
+	for _, layer := range a.Manifest[0].Layers {
+		if _, ok := a.Files[layer]; !ok {
+			t.Errorf("layer %s missing from the merged archive", layer)
+		}
+	}
+}
+
+func TestSaveDeltaMissingDiffIDs(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	base := registrytest.FileLayer(map[string]string{"etc/os-release": "base"})
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", base))
+	baselineFile := filepath.Join(dir, "app-1.0.tgz")
+	if _, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, baselineFile, nil); err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	baseline, err := archive.LoadBaseline(baselineFile)
+	if err != nil {
+		t.Fatalf("load baseline error: %+v", err)
+	}
+
+	// a manifest of two layers with the config of the one layer image
+	img := registrytest.NewImage("linux/amd64", base, registrytest.FileLayer(map[string]string{"app": "v2"}))
+	short := registrytest.NewImage("linux/amd64", base)
+	var m map[string]interface{}
+	if err = json.Unmarshal(img.Manifest, &m); err != nil {
+		t.Fatal(err)
+	}
+	m["config"] = map[string]interface{}{"mediaType": manifest.DockerV2Schema2ConfigMediaType, "digest": digest.FromBytes(short.Config), "size": len(short.Config)}
+	img.Manifest, _ = json.Marshal(m)
+	img.Config = short.Config
+	reg.PushImage("team/app", "2.0", img)
+
+	_, err = newTestClient(t, reg, "team/app:2.0", "", "").Save(nil, []string{"amd64"}, filepath.Join(dir, "app-2.0.tgz"), &SaveOptions{Baseline: baseline})
+	if err == nil || !strings.Contains(err.Error(), "1 diff_ids but the manifest has 2 layers") {
+		t.Errorf("expected a diff_ids error, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/tools"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/opencontainers/go-digest"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// blobDownload is a layer blob to download into file
+type blobDownload struct {
+	layer manifest.LayerInfo
+	file  string
+	// done is called once the blob is written and verified, concurrently with the other downloads
+	done func(file string) error
+}
+
+// downloadBlobs downloads the blobs in parallel with a progress bar each and returns the first error
+func (c *Client) downloadBlobs(downloads []blobDownload) error {
+	pw := c.newProgressWriter(len(downloads))
+	rendering := false
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var downloadErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if downloadErr == nil {
+			downloadErr = err
+		}
+	}
+
+	for _, d := range downloads {
+		layer := d.layer
+		blob, size, err := c.source.GetBlob(c.ctx, types.BlobInfo{Digest: layer.Digest, URLs: layer.URLs, Size: layer.Size}, none.NoCache)
+		if err != nil {
+			fail(fmt.Errorf("get blob %s error: %+v", layer.Digest, err))
+			break
+		}
+		tracker := &progress.Tracker{
+			Message: fmt.Sprintf("[%s]", string(layer.Digest[7:19])),
+			Total:   size,
+			Units:   progress.UnitsBytes,
+		}
+
+		if !rendering && c.progress {
+			rendering = true
+			go pw.Render()
+		}
+		pw.AppendTracker(tracker)
+
+		wg.Add(1)
+		go func(d blobDownload) {
+			defer wg.Done()
+			err := writeBlob(d.file, blob, size, layer.Digest, tracker)
+			if err == nil && d.done != nil {
+				err = d.done(d.file)
+			}
+			if err != nil {
+				fail(err)
+			}
+		}(d)
+	}
+
+	if rendering {
+		time.Sleep(time.Second)
+	}
+	wg.Wait()
+	for pw.IsRenderInProgress() {
+		time.Sleep(time.Millisecond * 100)
+	}
+	return downloadErr
+}
+
+// writeBlob downloads a blob into filename, failing when its content does not match the digest of the manifest
+func writeBlob(filename string, blob io.ReadCloser, size int64, expected digest.Digest, tracker *progress.Tracker) error {
+	if err := expected.Validate(); err != nil {
+		blob.Close()
+		tracker.MarkAsErrored()
+		return fmt.Errorf("invalid blob digest %s: %+v", expected, err)
+	}
+	verifier := expected.Verifier()
+	src := struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(blob, verifier), blob}
+	if err := tools.WriteBufferedFile(filename, src, size, tracker); err != nil {
+		tracker.MarkAsErrored()
+		return fmt.Errorf("download blob %s error: %+v", expected, err)
+	}
+	if !verifier.Verified() {
+		tracker.MarkAsErrored()
+		os.Remove(filename)
+		return fmt.Errorf("blob %s does not match its digest, the registry served another content", expected)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/rootfs"
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Export writes the root filesystem of the image matching the filters to output,
+// a tar file if output ends with .tar, a directory otherwise
+func (c *Client) Export(osFilterList, archFilterList []string, output string) (string, error) {
+	if output == "" {
+		output = fmt.Sprintf("%s_rootfs.tar", c.baseName())
+	}
+	policyKey, err := c.checkPolicy()
+	if err != nil {
+		return "", err
+	}
+
+	err = c.initClient()
+	if err != nil {
+		return "", err
+	}
+	defer c.source.Close()
+
+	manifestBytes, manifestType, err := c.source.GetManifest(c.ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("get manifest error: %+v", err)
+	}
+	manifestInfo, err := c.resolveManifest(manifestBytes, manifestType, osFilterList, archFilterList)
+	if err != nil {
+		return "", err
+	}
+	if policyKey != nil {
+		topDigest, err := manifest.Digest(manifestBytes)
+		if err != nil {
+			return "", fmt.Errorf("compute manifest digest error: %+v", err)
+		}
+		signed, err := c.verifySignature(policyKey, []digest.Digest{topDigest, *manifestInfo.Digest})
+		if err != nil {
+			return "", err
+		}
+		c.printf("Verified signature of %s\n", signed)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "imsave-export-")
+	if err != nil {
+		return "", fmt.Errorf("create temp dir error: %+v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	layers, err := c.downloadLayers(manifestInfo.Obj, tmpDir)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasSuffix(output, ".tar") {
+		err = rootfs.ExtractDir(output, layers)
+		if err != nil {
+			return "", fmt.Errorf("extract %s error: %+v", output, err)
+		}
+		return output, nil
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return "", fmt.Errorf("create %s error: %+v", output, err)
+	}
+	defer f.Close()
+	err = rootfs.WriteTar(f, layers)
+	if err != nil {
+		return "", fmt.Errorf("write %s error: %+v", output, err)
+	}
+	return output, f.Close()
+}
+
+// downloadLayers downloads the layer blobs of the manifest into dir, bottom layer first
+func (c *Client) downloadLayers(m manifest.Manifest, dir string) ([]rootfs.Layer, error) {
+	var infos []manifest.LayerInfo
+	for _, layer := range m.LayerInfos() {
+		// throwaway entries of schema1 images only carry history
+		if !layer.EmptyLayer {
+			infos = append(infos, layer)
+		}
+	}
+
+	var layers []rootfs.Layer
+	var downloads []blobDownload
+	for i, layer := range infos {
+		blobFile := filepath.Join(dir, fmt.Sprintf("%d.layer", i))
+		layers = append(layers, func() (io.ReadCloser, error) {
+			return openLayer(blobFile)
+		})
+		downloads = append(downloads, blobDownload{layer: layer, file: blobFile})
+	}
+	if err := c.downloadBlobs(downloads); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+type layerReader struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (r *layerReader) Close() error {
+	r.ReadCloser.Close()
+	return r.f.Close()
+}
+
+// openLayer opens the uncompressed content of a downloaded layer blob
+func openLayer(blobFile string) (io.ReadCloser, error) {
+	f, err := os.Open(blobFile)
+	if err != nil {
+		return nil, err
+	}
+	r, err := archive.Decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open layer %s error: %+v", blobFile, err)
+	}
+	return &layerReader{ReadCloser: r, f: f}, nil
+}
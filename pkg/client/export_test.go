@@ -0,0 +1,73 @@
+package client
+
+import (
+	"archive/tar"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64",
+		registrytest.FileLayer(map[string]string{"etc/os-release": "test", "tmp/build.log": "log"}),
+		registrytest.Layer(registrytest.File{Name: "tmp/.wh.build.log"}, registrytest.File{Name: "app/run.sh", Content: "echo hello", Mode: 0755}))
+	reg.PushImage("team/app", "1.0", img)
+
+	output, err := newTestClient(t, reg, "team/app:1.0", "", "").Export(nil, []string{"amd64"}, filepath.Join(dir, "rootfs.tar"))
+	if err != nil {
+		t.Fatalf("export error: %+v", err)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if len(names) != 2 || names[0] != "etc/os-release" || names[1] != "app/run.sh" {
+		t.Errorf("unexpected entries: %v", names)
+	}
+
+	output, err = newTestClient(t, reg, "team/app:1.0", "", "").Export(nil, []string{"amd64"}, filepath.Join(dir, "rootfs"))
+	if err != nil {
+		t.Fatalf("export error: %+v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(output, "app/run.sh")); err != nil || string(content) != "echo hello" {
+		t.Errorf("unexpected app/run.sh: %q %+v", content, err)
+	}
+	if _, err = os.Stat(filepath.Join(output, "tmp/build.log")); err == nil {
+		t.Errorf("removed file was extracted")
+	}
+}
+
+func TestExportZstd(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PushImage("team/app", "1.0", registrytest.NewOCIZstdImage("linux/amd64",
+		registrytest.ZstdLayer(registrytest.FileLayer(map[string]string{"etc/os-release": "test"})),
+		registrytest.ZstdLayer(registrytest.FileLayer(map[string]string{"app/run.sh": "echo hello"}))))
+
+	output, err := newTestClient(t, reg, "team/app:1.0", "", "").Export(nil, []string{"amd64"}, filepath.Join(dir, "rootfs"))
+	if err != nil {
+		t.Fatalf("export error: %+v", err)
+	}
+	for name, want := range map[string]string{"etc/os-release": "test", "app/run.sh": "echo hello"} {
+		if content, err := os.ReadFile(filepath.Join(output, name)); err != nil || string(content) != want {
+			t.Errorf("unexpected %s: %q %+v", name, content, err)
+		}
+	}
+}
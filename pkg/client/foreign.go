@@ -0,0 +1,34 @@
+package client
+
+import (
+	"fmt"
+	"github.com/containers/image/v5/manifest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// ForeignLayersDownload downloads foreign layers from their URLs, falling back to the registry
+	ForeignLayersDownload = "download"
+	// ForeignLayersSkip does not download foreign layers but records them in the LayerSources of manifest.json,
+	// the host loading the archive must already have them, like the base layers of Windows images
+	ForeignLayersSkip = "skip"
+)
+
+// isForeignLayer reports whether the layer is non-distributable, e.g. a Windows base layer
+func isForeignLayer(layer manifest.LayerInfo) bool {
+	switch layer.MediaType {
+	case manifest.DockerV2Schema2ForeignLayerMediaType, manifest.DockerV2Schema2ForeignLayerMediaTypeGzip,
+		specsv1.MediaTypeImageLayerNonDistributable, specsv1.MediaTypeImageLayerNonDistributableGzip,
+		specsv1.MediaTypeImageLayerNonDistributableZstd:
+		return true
+	}
+	return false
+}
+
+func validateForeignLayers(mode string) error {
+	switch mode {
+	case "", ForeignLayersDownload, ForeignLayersSkip:
+		return nil
+	}
+	return fmt.Errorf("unsupported foreign layers mode: %s", mode)
+}
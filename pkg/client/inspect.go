@@ -0,0 +1,184 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"time"
+)
+
+type PlatformInfo struct {
+	OS           string        `json:"os"`
+	Architecture string        `json:"architecture"`
+	Variant      string        `json:"variant,omitempty"`
+	OSVersion    string        `json:"osVersion,omitempty"`
+	Digest       digest.Digest `json:"digest"`
+}
+
+type LayerSummary struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+}
+
+// ImageInspect describes a remote image, gathered from its manifest and config blob only
+type ImageInspect struct {
+	Reference  string            `json:"reference"`
+	MediaType  string            `json:"mediaType"`
+	Digest     digest.Digest     `json:"digest"`
+	Platforms  []PlatformInfo    `json:"platforms,omitempty"`
+	Selected   *PlatformInfo     `json:"selected,omitempty"`
+	Layers     []LayerSummary    `json:"layers"`
+	TotalSize  int64             `json:"totalSize"`
+	Created    *time.Time        `json:"created,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Cmd        []string          `json:"cmd,omitempty"`
+	Env        []string          `json:"env,omitempty"`
+}
+
+// Digest returns the digest of the manifest the reference resolves to, the one Save records in its report
+func (c *Client) Digest() (digest.Digest, error) {
+	err := c.initClient()
+	if err != nil {
+		return "", err
+	}
+	defer c.source.Close()
+
+	manifestBytes, _, err := c.source.GetManifest(c.ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("get manifest error: %+v", err)
+	}
+	manifestDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return "", fmt.Errorf("compute manifest digest error: %+v", err)
+	}
+	return manifestDigest, nil
+}
+
+// Inspect gathers information about the remote image without downloading any layer. The manifest of the
+// platform matching the filters is selected from a list; when there is none, only the platforms are listed
+// unless requirePlatform is set, the filters being given by the user rather than defaulting to the host.
+func (c *Client) Inspect(osFilterList, archFilterList []string, requirePlatform bool) (*ImageInspect, error) {
+	err := c.initClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.source.Close()
+
+	manifestBytes, manifestType, err := c.source.GetManifest(c.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get manifest error: %+v", err)
+	}
+	manifestDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return nil, fmt.Errorf("compute manifest digest error: %+v", err)
+	}
+
+	res := &ImageInspect{
+		Reference: c.sourceRef.DockerReference().String(),
+		MediaType: manifestType,
+		Digest:    manifestDigest,
+	}
+
+	switch manifestType {
+	case manifest.DockerV2ListMediaType:
+		list, err := manifest.Schema2ListFromManifest(manifestBytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range list.Manifests {
+			res.Platforms = append(res.Platforms, PlatformInfo{
+				OS:           m.Platform.OS,
+				Architecture: m.Platform.Architecture,
+				Variant:      m.Platform.Variant,
+				OSVersion:    m.Platform.OSVersion,
+				Digest:       m.Digest,
+			})
+		}
+	case specsv1.MediaTypeImageIndex:
+		index, err := manifest.OCI1IndexFromManifest(manifestBytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			p := PlatformInfo{Digest: m.Digest}
+			if m.Platform != nil {
+				p.OS = m.Platform.OS
+				p.Architecture = m.Platform.Architecture
+				p.Variant = m.Platform.Variant
+				p.OSVersion = m.Platform.OSVersion
+			}
+			res.Platforms = append(res.Platforms, p)
+		}
+	}
+
+	if manifest.MIMETypeIsMultiImage(manifestType) && !requirePlatform && !hasPlatform(res.Platforms, osFilterList, archFilterList) {
+		return res, nil
+	}
+
+	manifestInfo, err := c.resolveManifest(manifestBytes, manifestType, osFilterList, archFilterList)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range manifestInfo.Obj.LayerInfos() {
+		res.Layers = append(res.Layers, LayerSummary{Digest: layer.Digest, MediaType: layer.MediaType, Size: layer.Size})
+		if layer.Size > 0 {
+			res.TotalSize += layer.Size
+		}
+	}
+
+	var config specsv1.Image
+	configInfo := manifestInfo.Obj.ConfigInfo()
+	if configInfo.Digest != "" {
+		blob, _, err := c.source.GetBlob(c.ctx, types.BlobInfo{Digest: configInfo.Digest, URLs: configInfo.URLs, Size: configInfo.Size}, none.NoCache)
+		if err != nil {
+			return nil, fmt.Errorf("load config info error: %+v", err)
+		}
+		defer blob.Close()
+		configBytes, err := io.ReadAll(blob)
+		if err != nil {
+			return nil, fmt.Errorf("load config blob error: %+v", err)
+		}
+		if err = json.Unmarshal(configBytes, &config); err != nil {
+			return nil, fmt.Errorf("parse config blob error: %+v", err)
+		}
+	} else if s1, ok := manifestInfo.Obj.(*manifest.Schema1); ok && len(s1.History) > 0 {
+		// schema1 carries the config of the top layer in its first history entry
+		if err = json.Unmarshal([]byte(s1.History[0].V1Compatibility), &config); err != nil {
+			return nil, fmt.Errorf("parse v1 compatibility error: %+v", err)
+		}
+	}
+
+	res.Selected = &PlatformInfo{
+		OS:           config.OS,
+		Architecture: config.Architecture,
+		Variant:      config.Variant,
+		OSVersion:    config.OSVersion,
+		Digest:       *manifestInfo.Digest,
+	}
+	res.Created = config.Created
+	res.Labels = config.Config.Labels
+	res.Entrypoint = config.Config.Entrypoint
+	res.Cmd = config.Config.Cmd
+	res.Env = config.Config.Env
+
+	return res, nil
+}
+
+// hasPlatform tells whether one of the platforms of a list matches the filters
+func hasPlatform(platforms []PlatformInfo, osFilterList, archFilterList []string) bool {
+	for _, p := range platforms {
+		spec := &manifest.Schema2PlatformSpec{OS: p.OS, OSVersion: p.OSVersion, Architecture: p.Architecture, Variant: p.Variant}
+		if platformValidate(osFilterList, archFilterList, spec) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInspectList(t *testing.T) {
+	reg := newTestRegistry(t)
+	amd64 := registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"arch": "amd64"}))
+	arm64 := registrytest.NewImage("linux/arm64/v8", registrytest.FileLayer(map[string]string{"arch": "arm64"}))
+	index := reg.PushIndex("library/app", "1.0", amd64, arm64)
+
+	info, err := newTestClient(t, reg, "library/app:1.0", "", "").Inspect(nil, []string{"arm64"}, true)
+	if err != nil {
+		t.Fatalf("inspect error: %+v", err)
+	}
+	if info.Digest != index || len(info.Platforms) != 2 || info.Platforms[1].Variant != "v8" || info.Platforms[1].Digest != arm64.Digest() {
+		t.Errorf("unexpected platforms: %+v", info)
+	}
+	if info.Selected == nil || info.Selected.Architecture != "arm64" || info.Selected.Digest != arm64.Digest() {
+		t.Fatalf("unexpected selected platform: %+v", info.Selected)
+	}
+	if len(info.Layers) != 1 || info.TotalSize != int64(len(arm64.Layers[0])) {
+		t.Errorf("unexpected layers: %+v", info.Layers)
+	}
+	if info.Created == nil || !reflect.DeepEqual(info.Cmd, []string{"/bin/sh"}) || len(info.Env) != 1 {
+		t.Errorf("unexpected config: %+v", info)
+	}
+}
+
+func TestInspectNoMatchingPlatform(t *testing.T) {
+	reg := newTestRegistry(t)
+	arm64 := registrytest.NewImage("linux/arm64", registrytest.FileLayer(map[string]string{"arch": "arm64"}))
+	reg.PushIndex("library/app", "1.0", arm64)
+
+	// the host platform is missing, the platforms are listed anyway
+	info, err := newTestClient(t, reg, "library/app:1.0", "", "").Inspect(nil, []string{"s390x"}, false)
+	if err != nil {
+		t.Fatalf("inspect error: %+v", err)
+	}
+	if len(info.Platforms) != 1 || info.Platforms[0].Architecture != "arm64" || info.Selected != nil || info.Layers != nil {
+		t.Errorf("unexpected result: %+v", info)
+	}
+	for _, req := range reg.Requests() {
+		if strings.Contains(req, "/blobs/") {
+			t.Errorf("blob downloaded without a selected platform: %s", req)
+		}
+	}
+
+	// a platform asked for by the user must exist
+	_, err = newTestClient(t, reg, "library/app:1.0", "", "").Inspect(nil, []string{"s390x"}, true)
+	if err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("expected a mismatch error, got %v", err)
+	}
+}
+
+func TestInspectImage(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64",
+		registrytest.FileLayer(map[string]string{"etc/os-release": "test"}),
+		registrytest.FileLayer(map[string]string{"app/run.sh": "echo hello"}))
+	reg.PushImage("team/app", "1.0", img)
+
+	info, err := newTestClient(t, reg, "team/app:1.0", "", "").Inspect(nil, []string{"amd64"}, false)
+	if err != nil {
+		t.Fatalf("inspect error: %+v", err)
+	}
+	if info.Digest != img.Digest() || info.Platforms != nil || info.MediaType != img.MediaType {
+		t.Errorf("unexpected result: %+v", info)
+	}
+	if info.Selected == nil || info.Selected.OS != "linux" || info.Selected.Architecture != "amd64" || info.Selected.Digest != img.Digest() {
+		t.Fatalf("unexpected selected platform: %+v", info.Selected)
+	}
+	if len(info.Layers) != 2 || info.TotalSize != int64(len(img.Layers[0])+len(img.Layers[1])) {
+		t.Errorf("unexpected layers: %+v", info.Layers)
+	}
+}
+
+func TestInspectSchema1(t *testing.T) {
+	reg := newTestRegistry(t)
+	img := registrytest.NewSchema1Image("amd64", registrytest.FileLayer(map[string]string{"etc/os-release": "test"}))
+	reg.PushImage("legacy/app", "1.0", img)
+
+	info, err := newTestClient(t, reg, "legacy/app:1.0", "", "").Inspect(nil, []string{"amd64"}, false)
+	if err != nil {
+		t.Fatalf("inspect error: %+v", err)
+	}
+	if info.Selected == nil || info.Selected.OS != "linux" || info.Selected.Architecture != "amd64" {
+		t.Fatalf("unexpected selected platform: %+v", info.Selected)
+	}
+	// the throwaway CMD entry has a layer too
+	if len(info.Layers) != 2 || !reflect.DeepEqual(info.Cmd, []string{"/bin/sh"}) || info.Created == nil {
+		t.Errorf("unexpected result: %+v", info)
+	}
+}
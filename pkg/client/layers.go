@@ -0,0 +1,112 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// v1Layer is the legacy metadata of a layer dir of a docker-archive
+type v1Layer struct {
+	id   digest.Digest
+	json []byte
+}
+
+// newV1Layers builds the json of every layer dir from the history of the config, the top layer gets the image config.
+// The ids are computed like docker save does: the digest of the json with the chain id of the layer and the parent id.
+func newV1Layers(config []byte, diffIDs []digest.Digest) ([]v1Layer, error) {
+	var image struct {
+		History []specsv1.History `json:"history"`
+	}
+	if err := json.Unmarshal(config, &image); err != nil {
+		return nil, fmt.Errorf("parse config error: %+v", err)
+	}
+	var top map[string]interface{}
+	if err := json.Unmarshal(config, &top); err != nil {
+		return nil, fmt.Errorf("parse config error: %+v", err)
+	}
+	delete(top, "history")
+	delete(top, "rootfs")
+
+	// history entries of empty layers, like ENV or CMD, have no layer dir
+	var history []specsv1.History
+	for _, h := range image.History {
+		if !h.EmptyLayer {
+			history = append(history, h)
+		}
+	}
+
+	// ChainIDs writes the chain ids into the slice it is given
+	chainIDs := identity.ChainIDs(append([]digest.Digest{}, diffIDs...))
+	layers := make([]v1Layer, 0, len(diffIDs))
+	var parent digest.Digest
+	for i := range diffIDs {
+		v1 := top
+		if i < len(diffIDs)-1 {
+			v1 = v1Config(history, i)
+		}
+
+		id, err := v1ID(v1, chainIDs[i], parent)
+		if err != nil {
+			return nil, err
+		}
+		v1["id"] = id.Encoded()
+		if parent != "" {
+			v1["parent"] = parent.Encoded()
+		}
+		content, err := json.Marshal(v1)
+		if err != nil {
+			return nil, fmt.Errorf("marshal layer json error: %+v", err)
+		}
+		delete(v1, "id")
+		delete(v1, "parent")
+
+		layers = append(layers, v1Layer{id: id, json: content})
+		parent = id
+	}
+	return layers, nil
+}
+
+// v1Config is the json of a layer below the top one. Like docker save it only holds the created time
+// and an empty container config, their values are taken from the history entry if any.
+func v1Config(history []specsv1.History, i int) map[string]interface{} {
+	v1 := map[string]interface{}{
+		"created": "1970-01-01T00:00:00Z",
+		"container_config": map[string]interface{}{
+			"Hostname": "", "Domainname": "", "User": "", "AttachStdin": false, "AttachStdout": false, "AttachStderr": false,
+			"Tty": false, "OpenStdin": false, "StdinOnce": false, "Env": nil, "Cmd": nil, "Image": "",
+			"Volumes": nil, "WorkingDir": "", "Entrypoint": nil, "OnBuild": nil, "Labels": nil,
+		},
+	}
+	if i >= len(history) {
+		return v1
+	}
+
+	h := history[i]
+	if h.Created != nil {
+		v1["created"] = h.Created
+	}
+	if h.CreatedBy != "" {
+		v1["container_config"].(map[string]interface{})["Cmd"] = []string{h.CreatedBy}
+	}
+	return v1
+}
+
+// v1ID is the id docker gives to a layer dir, see CreateID in github.com/docker/docker/image/v1
+func v1ID(v1 map[string]interface{}, chainID, parent digest.Digest) (digest.Digest, error) {
+	config := make(map[string]interface{}, len(v1)+2)
+	for k, v := range v1 {
+		config[k] = v
+	}
+	config["layer_id"] = chainID
+	if parent != "" {
+		config["parent"] = parent
+	}
+	content, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("marshal layer json error: %+v", err)
+	}
+	return digest.FromBytes(content), nil
+}
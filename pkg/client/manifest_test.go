@@ -0,0 +1,40 @@
+package client
+
+import (
+	"github.com/containers/image/v5/manifest"
+	"testing"
+)
+
+func TestPlatformValidate(t *testing.T) {
+	amd64 := &manifest.Schema2PlatformSpec{OS: "linux", Architecture: "amd64"}
+	armv7 := &manifest.Schema2PlatformSpec{OS: "linux", Architecture: "arm", Variant: "v7"}
+	windows := &manifest.Schema2PlatformSpec{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1234"}
+	unknown := &manifest.Schema2PlatformSpec{}
+
+	cases := []struct {
+		name     string
+		os, arch []string
+		platform *manifest.Schema2PlatformSpec
+		expected bool
+	}{
+		{"no filter", nil, nil, amd64, true},
+		{"arch match", nil, []string{"amd64"}, amd64, true},
+		{"arch mismatch", nil, []string{"arm64"}, amd64, false},
+		{"one of several arch", nil, []string{"arm64", "amd64"}, amd64, true},
+		{"os match", []string{"linux"}, []string{"amd64"}, amd64, true},
+		{"os mismatch", []string{"windows"}, []string{"amd64"}, amd64, false},
+		{"arch without variant", nil, []string{"arm"}, armv7, true},
+		{"arch with variant", nil, []string{"arm:v7"}, armv7, true},
+		{"arch with other variant", nil, []string{"arm:v6"}, armv7, false},
+		{"arch prefix", nil, []string{"arm"}, &manifest.Schema2PlatformSpec{OS: "linux", Architecture: "arm64"}, false},
+		{"os version", []string{"windows:10.0.17763.1234"}, nil, windows, true},
+		{"other os version", []string{"windows:10.0.14393.1066"}, nil, windows, false},
+		{"unknown platform", []string{"linux"}, []string{"amd64"}, unknown, true},
+	}
+
+	for _, c := range cases {
+		if actual := platformValidate(c.os, c.arch, c.platform); actual != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeSplit(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	base := registrytest.FileLayer(map[string]string{"etc/os-release": "base"})
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", base, registrytest.FileLayer(map[string]string{"app": "v1"})))
+	reg.PushImage("team/web", "1.0", registrytest.NewImage("linux/amd64", base, registrytest.FileLayer(map[string]string{"web": "v1"})))
+
+	var archives []*archive.Archive
+	for _, ref := range []string{"team/app:1.0", "team/web:1.0"} {
+		output := filepath.Join(dir, path.Base(ref)+".tgz")
+		if _, err := newTestClient(t, reg, ref, "", "").Save(nil, []string{"amd64"}, output, nil); err != nil {
+			t.Fatalf("save error: %+v", err)
+		}
+		archives = append(archives, openArchive(t, output))
+	}
+
+	merged := filepath.Join(dir, "all.tgz")
+	// the same archive twice adds nothing
+	if err := archive.Merge(append(archives, archives[0]), merged); err != nil {
+		t.Fatalf("merge error: %+v", err)
+	}
+	all := openArchive(t, merged)
+	verifyArchive(t, all)
+	if len(all.Manifest) != 2 || len(all.Repositories) != 2 {
+		t.Fatalf("unexpected merged archive: %+v %+v", all.Manifest, all.Repositories)
+	}
+	if all.Manifest[0].Layers[0] != all.Manifest[1].Layers[0] {
+		t.Errorf("base layer not shared: %+v", all.Manifest)
+	}
+	layerDirs := make(map[string]bool)
+	for name := range all.Files {
+		if path.Base(name) == "layer.tar" {
+			layerDirs[path.Dir(name)] = true
+		}
+	}
+	if len(layerDirs) != 3 {
+		t.Errorf("expected 3 layer dirs, got %v", layerDirs)
+	}
+
+	outputs, err := archive.Split(all, dir)
+	if err != nil {
+		t.Fatalf("split error: %+v", err)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("unexpected archives: %v", outputs)
+	}
+	for i, output := range outputs {
+		a := openArchive(t, output)
+		verifyArchive(t, a)
+		if !reflect.DeepEqual(a.Manifest, archives[i].Manifest) || !reflect.DeepEqual(a.Repositories, archives[i].Repositories) {
+			t.Errorf("%s: unexpected image %+v %+v", output, a.Manifest, a.Repositories)
+		}
+		if len(a.Files) != len(archives[i].Files) {
+			t.Errorf("%s: expected %d files, got %d", output, len(archives[i].Files), len(a.Files))
+		}
+	}
+}
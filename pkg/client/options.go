@@ -0,0 +1,34 @@
+package client
+
+import "github.com/DockerContainerService/image-save/pkg/archive"
+
+// SaveOptions tunes what is written in addition to the image itself, nil means the defaults
+type SaveOptions struct {
+	// Artifacts saves the cosign signatures, attestations, SBOMs and OCI referrers of the image
+	// as an OCI layout in the artifacts dir of the archive
+	Artifacts bool
+	// VerifyKey aborts the save before any layer is downloaded unless the manifest has a cosign signature made by the key
+	VerifyKey *PublicKey
+	// ForeignLayers is ForeignLayersDownload (the default) or ForeignLayersSkip
+	ForeignLayers string
+	// Baseline leaves out of the archive the layers already shipped in the baseline, the archive is a delta
+	// one listing them in archive.DeltaFile
+	Baseline *archive.Baseline
+	// Squash merges the layers of the image into a single one
+	Squash bool
+	// TagAs tags the image with these references instead of its own, see repoUrl.repoTags
+	TagAs []string
+	// Tags are added to the references the image is tagged with
+	Tags []string
+	// NoTags drops the own references of the image, it is untagged unless Tags are given
+	NoTags bool
+	// SplitSize splits the archive into volumes of at most SplitSize bytes listed in <output>.parts.json, 0 keeps a single file
+	SplitSize int64
+}
+
+func (o *SaveOptions) splitSize() int64 {
+	if o == nil {
+		return 0
+	}
+	return o.SplitSize
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Policy decides which images may be saved. Rules are evaluated in order and the first matching rule wins,
+// Default applies when no rule matches.
+//
+//	{
+//	  "default": "deny",
+//	  "rules": [
+//	    {"registry": "docker.io", "repository": "library/*", "action": "allow"},
+//	    {"registry": "*.internal", "action": "allow", "requireDigest": true, "signedBy": "/etc/imsave/cosign.pub"}
+//	  ]
+//	}
+type Policy struct {
+	Default string       `json:"default"`
+	Rules   []PolicyRule `json:"rules"`
+}
+
+type PolicyRule struct {
+	// Registry and Repository are globs, `*` does not match `/` while `**` does. Empty matches everything.
+	// Docker hub images are matched as registry docker.io and repository library/<name>.
+	Registry   string `json:"registry,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Action     string `json:"action"`
+	// RequireDigest only allows references pinned by digest, e.g. nginx@sha256:...
+	RequireDigest bool `json:"requireDigest,omitempty"`
+	// SignedBy is the path of a public key the image must have a cosign signature from
+	SignedBy string `json:"signedBy,omitempty"`
+	// Reason is added to the denial message
+	Reason string `json:"reason,omitempty"`
+
+	registry, repository *regexp.Regexp
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy %s error: %+v", path, err)
+	}
+	var p Policy
+	if err = json.Unmarshal(content, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s error: %+v", path, err)
+	}
+
+	if p.Default == "" {
+		p.Default = PolicyAllow
+	}
+	if p.Default != PolicyAllow && p.Default != PolicyDeny {
+		return nil, fmt.Errorf("policy %s: invalid default %q", path, p.Default)
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Action != PolicyAllow && rule.Action != PolicyDeny {
+			return nil, fmt.Errorf("policy %s: rule %d has invalid action %q", path, i+1, rule.Action)
+		}
+		rule.registry = globRegexp(rule.Registry)
+		rule.repository = globRegexp(rule.Repository)
+	}
+	return &p, nil
+}
+
+func globRegexp(glob string) *regexp.Regexp {
+	if glob == "" {
+		return nil
+	}
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.ReplaceAll(expr, `\*\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\*`, "[^/]*")
+	expr = strings.ReplaceAll(expr, `\?`, "[^/]")
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// Evaluate returns the rule allowing the reference, or an error explaining the denial
+func (p *Policy) Evaluate(ref reference.Named) (*PolicyRule, error) {
+	registry := reference.Domain(ref)
+	repository := reference.Path(ref)
+	_, pinned := ref.(reference.Digested)
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.registry != nil && !rule.registry.MatchString(registry) {
+			continue
+		}
+		if rule.repository != nil && !rule.repository.MatchString(repository) {
+			continue
+		}
+
+		if rule.Action == PolicyDeny {
+			return nil, fmt.Errorf("policy denied saving %s: %s is denied by rule %d%s", ref, reference.TrimNamed(ref), i+1, rule.reason())
+		}
+		if rule.RequireDigest && !pinned {
+			return nil, fmt.Errorf("policy denied saving %s: rule %d requires the reference to be pinned by digest%s", ref, i+1, rule.reason())
+		}
+		return rule, nil
+	}
+
+	if p.Default == PolicyDeny {
+		return nil, fmt.Errorf("policy denied saving %s: no rule allows registry %s and repository %s", ref, registry, repository)
+	}
+	return &PolicyRule{Action: PolicyAllow}, nil
+}
+
+func (r *PolicyRule) reason() string {
+	if r.Reason == "" {
+		return ""
+	}
+	return " (" + r.Reason + ")"
+}
+
+// SetPolicy checks the reference of the client against the policy, the policy is checked again by Save
+func (c *Client) SetPolicy(p *Policy) error {
+	c.policy = p
+	_, err := c.checkPolicy()
+	return err
+}
+
+// checkPolicy returns the key the image must be signed with according to the policy, if any
+func (c *Client) checkPolicy() (*PublicKey, error) {
+	if c.policy == nil {
+		return nil, nil
+	}
+	ref, err := c.repo.normalized()
+	if err != nil {
+		return nil, err
+	}
+	rule, err := c.policy.Evaluate(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// a mirror serves the image instead of the registry of the reference, it must be allowed too
+	pulled, err := c.repo.pulled()
+	if err != nil {
+		return nil, err
+	}
+	if reference.Domain(pulled) != reference.Domain(ref) {
+		mirrorRule, err := c.policy.Evaluate(pulled)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %s of %s: %+v", c.repo.registry, ref, err)
+		}
+		if rule.SignedBy == "" {
+			rule = mirrorRule
+		}
+	}
+	if rule.SignedBy == "" {
+		return nil, nil
+	}
+	return LoadPublicKey(rule.SignedBy)
+}
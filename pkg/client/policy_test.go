@@ -0,0 +1,145 @@
+package client
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"github.com/containers/image/v5/docker/reference"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestPolicy(t *testing.T, content string) (*Policy, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadPolicy(path)
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	pinned := "@sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name   string
+		policy string
+		ref    string
+		// denied is a part of the expected denial message, empty when allowed
+		denied string
+	}{
+		{"star stops at slash", `{"default": "deny", "rules": [{"registry": "docker.io", "repository": "library/*", "action": "allow"}]}`, "nginx", ""},
+		{"star does not cross slash", `{"default": "deny", "rules": [{"registry": "docker.io", "repository": "*", "action": "allow"}]}`, "team/app", "no rule allows"},
+		{"double star crosses slash", `{"default": "deny", "rules": [{"registry": "registry.internal", "repository": "team/**", "action": "allow"}]}`, "registry.internal/team/sub/app:1.0", ""},
+		{"registry glob", `{"default": "deny", "rules": [{"registry": "*.internal", "action": "allow"}]}`, "registry.internal/app", ""},
+		{"question mark", `{"default": "deny", "rules": [{"repository": "team/app?", "action": "allow"}]}`, "team/app2", ""},
+		{"first match wins deny", `{"rules": [{"repository": "library/nginx", "action": "deny", "reason": "use the internal build"}, {"action": "allow"}]}`, "nginx", "denied by rule 1 (use the internal build)"},
+		{"first match wins allow", `{"rules": [{"action": "allow"}, {"repository": "library/nginx", "action": "deny"}]}`, "nginx", ""},
+		{"require digest pinned", `{"rules": [{"action": "allow", "requireDigest": true}]}`, "nginx" + pinned, ""},
+		{"require digest unpinned", `{"rules": [{"action": "allow", "requireDigest": true}]}`, "nginx:1.25", "requires the reference to be pinned"},
+		{"default deny", `{"default": "deny", "rules": [{"registry": "quay.io", "action": "allow"}]}`, "nginx", "no rule allows registry docker.io and repository library/nginx"},
+		{"default allow", `{"rules": [{"registry": "quay.io", "action": "deny"}]}`, "nginx", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := loadTestPolicy(t, test.policy)
+			if err != nil {
+				t.Fatalf("load policy error: %+v", err)
+			}
+			ref, err := reference.ParseNormalizedNamed(test.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule, err := p.Evaluate(ref)
+			if test.denied == "" {
+				if err != nil || rule == nil || rule.Action != PolicyAllow {
+					t.Errorf("expected %s to be allowed, got %v", test.ref, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.denied) {
+				t.Errorf("expected %s to be denied with %q, got %v", test.ref, test.denied, err)
+			}
+		})
+	}
+}
+
+func TestLoadPolicyInvalid(t *testing.T) {
+	for policy, want := range map[string]string{
+		`{"default": "block"}`:                   `invalid default "block"`,
+		`{"rules": [{"action": "permit"}]}`:      `rule 1 has invalid action "permit"`,
+		`{"rules": [{"registry": "docker.io"}]}`: `rule 1 has invalid action ""`,
+		`{"rules": `:                             "parse policy",
+	} {
+		if _, err := loadTestPolicy(t, policy); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", policy, want, err)
+		}
+	}
+}
+
+func TestSavePolicyDenied(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PushImage("library/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"app": "v1"})))
+	p, err := loadTestPolicy(t, `{"default": "deny", "rules": [{"registry": "docker.io", "action": "allow"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestClient(t, reg, "library/app:1.0", "", "")
+	if err = c.SetPolicy(p); err == nil || !strings.Contains(err.Error(), "policy denied") {
+		t.Errorf("expected SetPolicy to deny the image, got %v", err)
+	}
+	output := filepath.Join(dir, "app.tgz")
+	if _, err = c.Save(nil, []string{"amd64"}, output, nil); err == nil || !strings.Contains(err.Error(), "policy denied") {
+		t.Errorf("expected Save to be denied, got %v", err)
+	}
+	if requests := reg.Requests(); len(requests) != 0 {
+		t.Errorf("denied image was pulled: %v", requests)
+	}
+	if _, err = os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("archive of a denied image was written")
+	}
+}
+
+func TestSavePolicyMirror(t *testing.T) {
+	p, err := loadTestPolicy(t, `{"default": "deny", "rules": [{"registry": "docker.io", "action": "allow"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// docker hub itself is docker.io
+	c, err := NewClient("nginx:1.25", "", "", "registry.hub.docker.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetPolicy(p); err != nil {
+		t.Errorf("expected docker hub to be allowed, got %v", err)
+	}
+
+	// another mirror serving docker hub images must be allowed by the policy too
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PushImage("library/nginx", "1.25", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"app": "v1"})))
+	c, err = NewClient("nginx:1.25", "", "", reg.Host(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetPolicy(p); err == nil || !strings.Contains(err.Error(), "mirror "+reg.Host()) {
+		t.Errorf("expected SetPolicy to deny the mirror, got %v", err)
+	}
+	output := filepath.Join(dir, "nginx.tgz")
+	if _, err = c.Save(nil, []string{"amd64"}, output, nil); err == nil || !strings.Contains(err.Error(), "policy denied") {
+		t.Errorf("expected Save to be denied, got %v", err)
+	}
+	if requests := reg.Requests(); len(requests) != 0 {
+		t.Errorf("image was pulled from a denied mirror: %v", requests)
+	}
+
+	p, err = loadTestPolicy(t, `{"default": "deny", "rules": [{"registry": "docker.io", "action": "allow"}, {"registry": "127.0.0.1:*", "action": "allow"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetPolicy(p); err != nil {
+		t.Errorf("expected the allowed mirror to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// registryClient talks to the distribution API directly for the endpoints containers/image does not cover
+type registryClient struct {
+	registry string
+	repo     string
+
+	username string
+	password string
+	insecure bool
+
+	client *http.Client
+	scheme string
+
+	mu            sync.Mutex
+	authorization string
+}
+
+func (c *Client) newRegistryClient() *registryClient {
+	httpClient := &http.Client{}
+	if c.repo.insecure {
+		httpClient.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	return &registryClient{
+		registry: c.repo.registry,
+		repo:     c.sourceRef.DockerReference().Name()[strings.Index(c.sourceRef.DockerReference().Name(), "/")+1:],
+		username: c.repo.username,
+		password: c.repo.password,
+		insecure: c.repo.insecure,
+		client:   httpClient,
+		scheme:   "https",
+	}
+}
+
+// get requests /v2/<repo>/<path>, answering the authentication challenge of the registry if needed
+func (r *registryClient) get(path string, accept ...string) (*http.Response, error) {
+	resp, err := r.do(path, accept)
+	if err != nil && r.insecure && r.scheme == "https" {
+		// insecure registries may only serve http
+		r.scheme = "http"
+		resp, err = r.do(path, accept)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err = r.authorize(challenge); err != nil {
+		return nil, err
+	}
+	return r.do(path, accept)
+}
+
+func (r *registryClient) do(path string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/v2/%s/%s", r.scheme, r.registry, r.repo, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accept {
+		req.Header.Add("Accept", a)
+	}
+	r.mu.Lock()
+	if r.authorization != "" {
+		req.Header.Set("Authorization", r.authorization)
+	}
+	r.mu.Unlock()
+	return r.client.Do(req)
+}
+
+func (r *registryClient) authorize(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		req, _ := http.NewRequest(http.MethodGet, "", nil)
+		req.SetBasicAuth(r.username, r.password)
+		r.mu.Lock()
+		r.authorization = req.Header.Get("Authorization")
+		r.mu.Unlock()
+		return nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return fmt.Errorf("invalid bearer realm in challenge: %s", challenge)
+		}
+		query := realm.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		query.Set("scope", fmt.Sprintf("repository:%s:pull", r.repo))
+		realm.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return err
+		}
+		if r.username != "" && r.password != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("get token error: %+v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("get token error: %s", resp.Status)
+		}
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return fmt.Errorf("decode token error: %+v", err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		r.mu.Lock()
+		r.authorization = "Bearer " + token.Token
+		r.mu.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("unsupported authentication challenge: %s", challenge)
+	}
+}
+
+// parseChallenge parses a WWW-Authenticate header like `Bearer realm="...",service="..."`
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return scheme, params
+}
+
+// readBody reads a successful response and closes it
+func readBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
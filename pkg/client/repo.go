@@ -2,7 +2,11 @@ package client
 
 import (
 	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/opencontainers/go-digest"
+	"path"
 	"strings"
+	"text/template"
 )
 
 type repoUrl struct {
@@ -12,6 +16,7 @@ type repoUrl struct {
 	namespace string
 	project   string
 	tag       string
+	digest    string
 
 	username string
 	password string
@@ -19,8 +24,16 @@ type repoUrl struct {
 }
 
 func parseRepoUrl(url, mirror string) (*repoUrl, error) {
+	// split off the digest of a pinned reference like name@sha256:...
+	name, dgst, pinned := strings.Cut(url, "@")
+	if pinned {
+		if _, err := digest.Parse(dgst); err != nil {
+			return nil, fmt.Errorf("invalid digest in repository url %v: %+v", url, err)
+		}
+	}
+
 	// split to registry/namespace/repoAndTag
-	slice := strings.SplitN(url, "/", 3)
+	slice := strings.SplitN(name, "/", 3)
 
 	// parse project and tag
 	var repo, tag string
@@ -31,8 +44,10 @@ func parseRepoUrl(url, mirror string) (*repoUrl, error) {
 	} else if len(s) == 2 {
 		repo = s[0]
 		tag = s[1]
+	} else if pinned {
+		// a pinned reference has no tag unless given
+		repo = s[0]
 	} else {
-		fmt.Printf("Using default tag: latest\n")
 		repo = s[0]
 		tag = "latest"
 	}
@@ -45,6 +60,7 @@ func parseRepoUrl(url, mirror string) (*repoUrl, error) {
 			namespace: slice[1],
 			project:   repo,
 			tag:       tag,
+			digest:    dgst,
 		}, nil
 	} else if len(slice) == 2 {
 		// first string is a domain
@@ -55,6 +71,7 @@ func parseRepoUrl(url, mirror string) (*repoUrl, error) {
 				namespace: "",
 				project:   repo,
 				tag:       tag,
+				digest:    dgst,
 			}, nil
 		}
 
@@ -64,6 +81,7 @@ func parseRepoUrl(url, mirror string) (*repoUrl, error) {
 			namespace: slice[0],
 			project:   repo,
 			tag:       tag,
+			digest:    dgst,
 		}, nil
 	} else {
 		return &repoUrl{
@@ -72,6 +90,141 @@ func parseRepoUrl(url, mirror string) (*repoUrl, error) {
 			namespace: "library",
 			project:   repo,
 			tag:       tag,
+			digest:    dgst,
 		}, nil
 	}
 }
+
+// defaultTag reports whether the url has neither tag nor digest, latest being used
+func (r *repoUrl) defaultTag() bool {
+	name, _, pinned := strings.Cut(r.url, "@")
+	slice := strings.Split(name, "/")
+	return !pinned && !strings.Contains(slice[len(slice)-1], ":")
+}
+
+func (r *repoUrl) withTag(tag string) *repoUrl {
+	n := *r
+	n.tag = tag
+	n.digest = ""
+	n.url = fmt.Sprintf("%s:%s", strings.TrimSuffix(strings.TrimSuffix(r.url, "@"+r.digest), ":"+r.tag), tag)
+	return &n
+}
+
+// normalized returns the fully qualified reference the user asked for, registries like docker.io are not replaced by the mirror
+func (r *repoUrl) normalized() (reference.Named, error) {
+	named, err := reference.ParseNormalizedNamed(r.url)
+	if err != nil {
+		return nil, fmt.Errorf("parse reference %s error: %+v", r.url, err)
+	}
+	return named, nil
+}
+
+// dockerHubRegistries are the hosts serving docker hub itself, pulling from them is pulling from docker.io
+var dockerHubRegistries = map[string]bool{
+	"docker.io":               true,
+	"index.docker.io":         true,
+	"registry-1.docker.io":    true,
+	"registry.hub.docker.com": true,
+}
+
+// pulled returns the reference of the image in the registry actually contacted, which is the mirror for docker hub images
+func (r *repoUrl) pulled() (reference.Named, error) {
+	registry := r.registry
+	if dockerHubRegistries[registry] {
+		registry = "docker.io"
+	}
+	ref := fmt.Sprintf("%s/%s:%s", registry, strings.Join([]string{r.namespace, r.project}, "/"), r.tag)
+	if r.digest != "" {
+		ref = fmt.Sprintf("%s/%s@%s", registry, strings.Join([]string{r.namespace, r.project}, "/"), r.digest)
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parse reference %s error: %+v", ref, err)
+	}
+	return named, nil
+}
+
+// TagTemplate is the data of the templates of --tag and --tag-as, e.g. registry.internal/{{.Repo}}:{{.Tag}}-approved
+type TagTemplate struct {
+	// Registry is the registry of the normalized reference, docker.io for docker hub images
+	Registry string
+	// Repo is the path of the repository in the registry, e.g. library/nginx
+	Repo string
+	// Name is the last element of Repo, e.g. nginx
+	Name string
+	Tag  string
+}
+
+// repoTags returns the references the image is tagged with in the archive.
+// tagAs replaces the reference of the image, noTags drops it, tags are added in any case.
+// A reference without tag keeps the tag of the image and every reference may be a TagTemplate.
+func (r *repoUrl) repoTags(tagAs, tags []string, noTags bool) ([]reference.NamedTagged, error) {
+	named, err := r.normalized()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	if !noTags {
+		refs = tagAs
+		if len(refs) == 0 && r.tag != "" {
+			// images pulled by digest only are not tagged
+			refs = []string{reference.TrimNamed(named).String()}
+		}
+	}
+	refs = append(append([]string{}, refs...), tags...)
+
+	data := TagTemplate{
+		Registry: reference.Domain(named),
+		Repo:     reference.Path(named),
+		Name:     path.Base(reference.Path(named)),
+		Tag:      r.tag,
+	}
+	var result []reference.NamedTagged
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if strings.Contains(ref, "{{") {
+			var b strings.Builder
+			tmpl, err := template.New("tag").Option("missingkey=error").Parse(ref)
+			if err == nil {
+				err = tmpl.Execute(&b, data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tag template %s error: %+v", ref, err)
+			}
+			ref = b.String()
+		}
+
+		tagged, err := r.tagged(ref)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tagged.String()] {
+			seen[tagged.String()] = true
+			result = append(result, tagged)
+		}
+	}
+	return result, nil
+}
+
+// tagged parses a reference to tag the image with, the tag of the image is used when it has none
+func (r *repoUrl) tagged(ref string) (reference.NamedTagged, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parse tag %s error: %+v", ref, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return nil, fmt.Errorf("tag %s must not have a digest", ref)
+	}
+	if tagged, ok := named.(reference.NamedTagged); ok {
+		return tagged, nil
+	}
+	if r.tag == "" {
+		return nil, fmt.Errorf("tag %s needs a tag, %s has none", ref, r.url)
+	}
+	tagged, err := reference.WithTag(named, r.tag)
+	if err != nil {
+		return nil, fmt.Errorf("tag %s error: %+v", ref, err)
+	}
+	return tagged, nil
+}
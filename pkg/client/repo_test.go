@@ -0,0 +1,114 @@
+package client
+
+import (
+	"github.com/containers/image/v5/docker/reference"
+	"strings"
+	"testing"
+)
+
+func TestParseRepoUrl(t *testing.T) {
+	const mirror = "registry.hub.docker.com"
+	cases := []struct {
+		url                                          string
+		registry, namespace, project, tag, digestStr string
+	}{
+		{"alpine", mirror, "library", "alpine", "latest", ""},
+		{"alpine:3.18", mirror, "library", "alpine", "3.18", ""},
+		{"bitnami/redis:7", mirror, "bitnami", "redis", "7", ""},
+		{"quay.io/coreos", "quay.io", "", "coreos", "latest", ""},
+		{"quay.io/coreos/etcd:v3.5.0", "quay.io", "coreos", "etcd", "v3.5.0", ""},
+		{"127.0.0.1:5000/team/app:1.0", "127.0.0.1:5000", "team", "app", "1.0", ""},
+		{"registry.example.com/a/b/c:1", "registry.example.com", "a", "b/c", "1", ""},
+		{"alpine@sha256:0123456789012345678901234567890123456789012345678901234567890123", mirror, "library", "alpine", "",
+			"sha256:0123456789012345678901234567890123456789012345678901234567890123"},
+		{"alpine:3.18@sha256:0123456789012345678901234567890123456789012345678901234567890123", mirror, "library", "alpine", "3.18",
+			"sha256:0123456789012345678901234567890123456789012345678901234567890123"},
+	}
+
+	for _, c := range cases {
+		repo, err := parseRepoUrl(c.url, mirror)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %+v", c.url, err)
+			continue
+		}
+		if repo.url != c.url || repo.registry != c.registry || repo.namespace != c.namespace ||
+			repo.project != c.project || repo.tag != c.tag || repo.digest != c.digestStr {
+			t.Errorf("%s: got %+v", c.url, repo)
+		}
+	}
+}
+
+func TestParseRepoUrlInvalid(t *testing.T) {
+	for _, url := range []string{"alpine:3:18", "alpine@sha256:123", "alpine@md5:0123"} {
+		if _, err := parseRepoUrl(url, "registry.hub.docker.com"); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+func TestWithTag(t *testing.T) {
+	cases := map[string]string{
+		"alpine":                      "alpine:3.18",
+		"alpine:latest":               "alpine:3.18",
+		"127.0.0.1:5000/team/app":     "127.0.0.1:5000/team/app:3.18",
+		"127.0.0.1:5000/team/app:1.0": "127.0.0.1:5000/team/app:3.18",
+		"alpine@sha256:0123456789012345678901234567890123456789012345678901234567890123": "alpine:3.18",
+	}
+	for url, expected := range cases {
+		repo, err := parseRepoUrl(url, "registry.hub.docker.com")
+		if err != nil {
+			t.Fatalf("%s: %+v", url, err)
+		}
+		n := repo.withTag("3.18")
+		if n.url != expected || n.tag != "3.18" || n.digest != "" {
+			t.Errorf("%s: got %+v", url, n)
+		}
+	}
+}
+
+func TestRepoTags(t *testing.T) {
+	cases := []struct {
+		url      string
+		tagAs    []string
+		tags     []string
+		expected string
+	}{
+		{"alpine", nil, nil, "alpine:latest"},
+		{"library/alpine:3.18", nil, nil, "alpine:3.18"},
+		{"bitnami/redis:7", nil, nil, "bitnami/redis:7"},
+		{"quay.io/coreos", nil, nil, "quay.io/coreos:latest"},
+		{"127.0.0.1:5000/team/app:1.0", nil, nil, "127.0.0.1:5000/team/app:1.0"},
+		{"alpine@sha256:0123456789012345678901234567890123456789012345678901234567890123", nil, nil, ""},
+		{"alpine:3.18@sha256:0123456789012345678901234567890123456789012345678901234567890123", nil, nil, "alpine:3.18"},
+		{"alpine:3.18", []string{"registry.local/base/alpine"}, nil, "registry.local/base/alpine:3.18"},
+		{"alpine:3.18", []string{"base:1", "docker.io/library/base:2"}, nil, "base:1,base:2"},
+		{"nginx:1.25", nil, []string{"registry.internal/base/{{.Name}}:{{.Tag}}-approved"}, "nginx:1.25,registry.internal/base/nginx:1.25-approved"},
+		{"quay.io/coreos/etcd:v3.5.0", []string{"mirror.local/{{.Registry}}/{{.Repo}}"}, []string{"etcd:latest"},
+			"mirror.local/quay.io/coreos/etcd:v3.5.0,etcd:latest"},
+	}
+	for _, c := range cases {
+		repo, err := parseRepoUrl(c.url, "registry.hub.docker.com")
+		if err != nil {
+			t.Fatalf("%s: %+v", c.url, err)
+		}
+		tags, err := repo.repoTags(c.tagAs, c.tags, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %+v", c.url, err)
+			continue
+		}
+		var got []string
+		for _, tagged := range tags {
+			got = append(got, reference.FamiliarString(tagged))
+		}
+		if strings.Join(got, ",") != c.expected {
+			t.Errorf("%s %v: got %v", c.url, c.tagAs, got)
+		}
+	}
+
+	repo, _ := parseRepoUrl("alpine@sha256:0123456789012345678901234567890123456789012345678901234567890123", "registry.hub.docker.com")
+	for _, tagAs := range []string{"base", "base@sha256:0123456789012345678901234567890123456789012345678901234567890123", "Invalid", "base:{{.Unknown}}"} {
+		if _, err := repo.repoTags([]string{tagAs}, nil, false); err == nil {
+			t.Errorf("%s: expected an error", tagAs)
+		}
+	}
+}
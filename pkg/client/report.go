@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/tools"
+	"github.com/opencontainers/go-digest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type LayerResult struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	// Foreign layers were not downloaded
+	Foreign bool `json:"foreign,omitempty"`
+	// External layers were left in the baseline of a delta archive
+	External bool `json:"external,omitempty"`
+}
+
+// ImageResult summarizes an image written to an archive
+type ImageResult struct {
+	Reference string `json:"reference"`
+	Tag       string `json:"tag"`
+	// RepoTags are the references the image is tagged with in the archive
+	RepoTags []string `json:"repoTags,omitempty"`
+	// Registry is the endpoint the image was pulled from, which is the mirror for docker hub images
+	Registry string `json:"registry"`
+	// Digest is the manifest digest the tag resolved to, PlatformDigest the one of the saved platform
+	Digest         digest.Digest `json:"digest"`
+	PlatformDigest digest.Digest `json:"platformDigest"`
+	Platform       string        `json:"platform"`
+	Layers         []LayerResult `json:"layers"`
+	// Artifacts is the number of signatures, attestations and SBOMs saved with the image
+	Artifacts    int `json:"artifacts,omitempty"`
+	SharedLayers int `json:"-"`
+}
+
+// Report describes an archive written by Save
+type Report struct {
+	Output  string         `json:"output"`
+	Sha256  string         `json:"sha256"`
+	Size    int64          `json:"size"`
+	Created time.Time      `json:"created"`
+	Images  []*ImageResult `json:"images"`
+	// Volumes are the files the archive was split into, Output does not exist then
+	Volumes []archive.Part `json:"volumes,omitempty"`
+}
+
+// newReport describes the archive of size bytes written to output, split into volumes if not nil
+func newReport(output string, size int64, images []*ImageResult, volumes *archive.Volumes) *Report {
+	r := &Report{
+		Output:  output,
+		Size:    size,
+		Created: time.Now().UTC(),
+		Images:  images,
+	}
+	if volumes != nil {
+		r.Sha256 = volumes.Sha256
+		r.Volumes = volumes.Parts
+	}
+	return r
+}
+
+// WriteSidecar writes <output>.sha256 in the sha256sum format and the report as <output>.json
+func (r *Report) WriteSidecar() error {
+	if r.Sha256 == "" {
+		sum, err := tools.Sha256File(r.Output)
+		if err != nil {
+			return err
+		}
+		r.Sha256 = sum
+	}
+	// the checksums of the volumes as the archive itself does not exist
+	sums := fmt.Sprintf("%s  %s\n", r.Sha256, filepath.Base(r.Output))
+	if len(r.Volumes) > 0 {
+		sums = ""
+		for _, part := range r.Volumes {
+			sums += fmt.Sprintf("%s  %s\n", part.Sha256, part.Name)
+		}
+	}
+	tools.WriteFile(fmt.Sprintf("%s.sha256", r.Output), []byte(sums))
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal report error: %+v", err)
+	}
+	tools.WriteFile(fmt.Sprintf("%s.json", r.Output), content)
+	return nil
+}
+
+// ReadReport reads a report written by WriteSidecar
+func ReadReport(path string) (*Report, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read report %s error: %+v", path, err)
+	}
+	var r Report
+	if err = json.Unmarshal(content, &r); err != nil {
+		return nil, fmt.Errorf("parse report %s error: %+v", path, err)
+	}
+	if r.Output == "" || len(r.Images) == 0 {
+		return nil, fmt.Errorf("%s is not a report of imsave", path)
+	}
+	return &r, nil
+}
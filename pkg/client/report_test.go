@@ -0,0 +1,127 @@
+package client
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/opencontainers/go-digest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport(output string) *Report {
+	return &Report{
+		Output:  output,
+		Size:    7,
+		Created: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		Images: []*ImageResult{{
+			Reference:      "docker.io/library/app:1.0",
+			Tag:            "1.0",
+			RepoTags:       []string{"app:1.0"},
+			Registry:       "registry.hub.docker.com",
+			Digest:         digest.FromString("index"),
+			PlatformDigest: digest.FromString("manifest"),
+			Platform:       "linux/amd64",
+			Layers:         []LayerResult{{Digest: digest.FromString("layer"), MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Size: 5}},
+		}},
+	}
+}
+
+// checkFile fails when the file does not hold exactly want
+func checkFile(t *testing.T, path, want string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Errorf("%s: got\n%s\nwant\n%s", filepath.Base(path), content, want)
+	}
+}
+
+func TestWriteSidecar(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "app.tgz")
+	if err := os.WriteFile(output, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := testReport(output)
+	if err := report.WriteSidecar(); err != nil {
+		t.Fatalf("write sidecar error: %+v", err)
+	}
+
+	sum := digest.FromString("archive").Encoded()
+	checkFile(t, output+".sha256", sum+"  app.tgz\n")
+	checkFile(t, output+".json", `{
+  "output": "`+output+`",
+  "sha256": "`+sum+`",
+  "size": 7,
+  "created": "2024-05-06T07:08:09Z",
+  "images": [
+    {
+      "reference": "docker.io/library/app:1.0",
+      "tag": "1.0",
+      "repoTags": [
+        "app:1.0"
+      ],
+      "registry": "registry.hub.docker.com",
+      "digest": "`+digest.FromString("index").String()+`",
+      "platformDigest": "`+digest.FromString("manifest").String()+`",
+      "platform": "linux/amd64",
+      "layers": [
+        {
+          "digest": "`+digest.FromString("layer").String()+`",
+          "mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip",
+          "size": 5
+        }
+      ]
+    }
+  ]
+}`)
+
+	read, err := ReadReport(output + ".json")
+	if err != nil {
+		t.Fatalf("read report error: %+v", err)
+	}
+	if !reflect.DeepEqual(read, report) {
+		t.Errorf("report changed by the round trip:\n%+v\n%+v", read, report)
+	}
+}
+
+func TestWriteSidecarVolumes(t *testing.T) {
+	// the archive was split, only its volumes exist
+	output := filepath.Join(t.TempDir(), "app.tgz")
+	report := testReport(output)
+	report.Sha256 = digest.FromString("archive").Encoded()
+	report.Volumes = []archive.Part{
+		{Name: "app.tgz.001", Size: 4, Sha256: digest.FromString("arch").Encoded()},
+		{Name: "app.tgz.002", Size: 3, Sha256: digest.FromString("ive").Encoded()},
+	}
+	if err := report.WriteSidecar(); err != nil {
+		t.Fatalf("write sidecar error: %+v", err)
+	}
+
+	checkFile(t, output+".sha256", report.Volumes[0].Sha256+"  app.tgz.001\n"+report.Volumes[1].Sha256+"  app.tgz.002\n")
+	read, err := ReadReport(output + ".json")
+	if err != nil {
+		t.Fatalf("read report error: %+v", err)
+	}
+	if !reflect.DeepEqual(read, report) {
+		t.Errorf("report changed by the round trip:\n%+v\n%+v", read, report)
+	}
+}
+
+func TestReadReportInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"other.json": `{"name": "app"}`, "broken.json": `{"output": `} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := ReadReport(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := ReadReport(filepath.Join(dir, "missing.json")); err == nil || !strings.Contains(err.Error(), "read report") {
+		t.Errorf("expected a read error, got %v", err)
+	}
+}
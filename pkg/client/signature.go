@@ -0,0 +1,263 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/tools"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ArtifactsDir is the directory of the archive holding the signatures as an OCI layout
+	ArtifactsDir = archive.ArtifactsDir
+
+	// cosign stores its artifacts in tags derived from the digest of the signed manifest
+	signatureTagSuffix   = ".sig"
+	attestationTagSuffix = ".att"
+	sbomTagSuffix        = ".sbom"
+)
+
+// cosignTag returns the tag cosign uses for an artifact attached to the manifest digest
+func cosignTag(d digest.Digest, suffix string) string {
+	return fmt.Sprintf("%s-%s%s", d.Algorithm(), d.Encoded(), suffix)
+}
+
+// ociLayoutWriter stores artifact manifests and their blobs as an OCI image layout
+type ociLayoutWriter struct {
+	dir   string
+	index specsv1.Index
+	blobs map[digest.Digest]bool
+}
+
+func newOCILayoutWriter(dir string) *ociLayoutWriter {
+	return &ociLayoutWriter{
+		dir: dir,
+		index: specsv1.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: specsv1.MediaTypeImageIndex,
+		},
+		blobs: make(map[digest.Digest]bool),
+	}
+}
+
+func (o *ociLayoutWriter) blobPath(d digest.Digest) string {
+	return filepath.Join(o.dir, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+func (o *ociLayoutWriter) hasBlob(d digest.Digest) bool {
+	return o.blobs[d]
+}
+
+func (o *ociLayoutWriter) writeBlob(d digest.Digest, r io.Reader) error {
+	tools.MkdirPath(filepath.Dir(o.blobPath(d)))
+	file, err := os.Create(o.blobPath(d))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	verifier := d.Verifier()
+	if _, err = io.Copy(file, io.TeeReader(r, verifier)); err != nil {
+		return fmt.Errorf("write blob %s error: %+v", d, err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its digest", d)
+	}
+	o.blobs[d] = true
+	return nil
+}
+
+// addManifest references an artifact manifest in index.json, ref is the tag it was found under if any
+func (o *ociLayoutWriter) addManifest(desc specsv1.Descriptor, ref string) {
+	for _, m := range o.index.Manifests {
+		if m.Digest == desc.Digest && m.Annotations[specsv1.AnnotationRefName] == ref {
+			return
+		}
+	}
+	if ref != "" {
+		desc.Annotations = map[string]string{specsv1.AnnotationRefName: ref}
+	}
+	o.index.Manifests = append(o.index.Manifests, desc)
+}
+
+func (o *ociLayoutWriter) close() error {
+	if len(o.index.Manifests) == 0 {
+		return nil
+	}
+	tools.MkdirPath(o.dir)
+	tools.WriteFile(filepath.Join(o.dir, specsv1.ImageLayoutFile), []byte(fmt.Sprintf(`{"imageLayoutVersion":"%s"}`, specsv1.ImageLayoutVersion)))
+	index, err := json.Marshal(o.index)
+	if err != nil {
+		return fmt.Errorf("marshal index.json error: %+v", err)
+	}
+	tools.WriteFile(filepath.Join(o.dir, "index.json"), index)
+	return nil
+}
+
+// saveArtifacts copies the cosign signatures, attestations and SBOMs and the OCI referrers
+// attached to the given manifest digests, it returns the number of artifact manifests found
+func (c *Client) saveArtifacts(o *ociLayoutWriter, digests []digest.Digest) (int, error) {
+	count := 0
+	for _, d := range digests {
+		for _, suffix := range []string{signatureTagSuffix, attestationTagSuffix, sbomTagSuffix} {
+			tag := cosignTag(d, suffix)
+			found, err := c.copyArtifact(o, tag, "")
+			if err != nil {
+				return count, err
+			}
+			if found {
+				logrus.Debugf("found %s", tag)
+				count++
+			}
+		}
+
+		referrers, err := c.listReferrers(d)
+		if err != nil {
+			return count, err
+		}
+		for _, desc := range referrers {
+			logrus.Debugf("found referrer %s of %s", desc.Digest, d)
+			if _, err = c.copyArtifact(o, "", desc.Digest); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// listReferrers asks the OCI 1.1 referrers API, falling back to the referrers tag schema
+func (c *Client) listReferrers(d digest.Digest) ([]specsv1.Descriptor, error) {
+	var indexBytes []byte
+	resp, err := c.newRegistryClient().get(fmt.Sprintf("referrers/%s", d), specsv1.MediaTypeImageIndex)
+	if err != nil {
+		return nil, fmt.Errorf("list referrers of %s error: %+v", d, err)
+	}
+	if resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), specsv1.MediaTypeImageIndex) {
+		indexBytes, err = readBody(resp)
+		if err != nil {
+			return nil, fmt.Errorf("list referrers of %s error: %+v", d, err)
+		}
+	} else {
+		resp.Body.Close()
+		indexBytes, _, err = c.getTaggedManifest(strings.Replace(d.String(), ":", "-", 1))
+		if isManifestUnknown(err) {
+			logrus.Debugf("no referrers found for %s: %+v", d, err)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get referrers tag of %s error: %+v", d, err)
+		}
+	}
+
+	var index specsv1.Index
+	if err = json.Unmarshal(indexBytes, &index); err != nil {
+		return nil, fmt.Errorf("parse referrers of %s error: %+v", d, err)
+	}
+	return index.Manifests, nil
+}
+
+// getTaggedManifest reads the manifest of another tag of the repository, the registry errors
+// are returned as is for isManifestUnknown
+func (c *Client) getTaggedManifest(tag string) ([]byte, string, error) {
+	other := c.WithTag(tag)
+	if err := other.initReference(); err != nil {
+		return nil, "", err
+	}
+	// opening the source already reads the manifest
+	source, err := other.sourceRef.NewImageSource(other.ctx, other.sysContext)
+	if err != nil {
+		return nil, "", err
+	}
+	defer source.Close()
+	return source.GetManifest(other.ctx, nil)
+}
+
+// isManifestUnknown tells whether the registry answered that the manifest does not exist,
+// some registries answer a plain 404 Not Found instead of MANIFEST_UNKNOWN
+func isManifestUnknown(err error) bool {
+	var ec errcode.ErrorCoder
+	if errors.As(err, &ec) && ec.ErrorCode() == v2.ErrorCodeManifestUnknown {
+		return true
+	}
+	var e errcode.Error
+	return errors.As(err, &e) && e.ErrorCode() == errcode.ErrorCodeUnknown && e.Message == http.StatusText(http.StatusNotFound)
+}
+
+// copyArtifact copies an artifact manifest, given by tag or digest, and its blobs into the layout.
+// A missing tag is not an error, false is returned instead, any other registry error is.
+func (c *Client) copyArtifact(o *ociLayoutWriter, tag string, d digest.Digest) (bool, error) {
+	var manifestBytes []byte
+	var manifestType string
+	var err error
+	if tag != "" {
+		manifestBytes, manifestType, err = c.getTaggedManifest(tag)
+		if isManifestUnknown(err) {
+			logrus.Debugf("get manifest of %s error: %+v", tag, err)
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("get manifest of %s error: %+v", tag, err)
+		}
+	} else {
+		manifestBytes, manifestType, err = c.source.GetManifest(c.ctx, &d)
+		if err != nil {
+			return false, fmt.Errorf("get manifest %s error: %+v", d, err)
+		}
+	}
+
+	manifestDigest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return false, fmt.Errorf("compute manifest digest error: %+v", err)
+	}
+
+	m, err := manifest.FromBlob(manifestBytes, manifestType)
+	if err != nil {
+		return false, fmt.Errorf("parse artifact manifest %s error: %+v", manifestDigest, err)
+	}
+	blobs := []types.BlobInfo{m.ConfigInfo()}
+	for _, layer := range m.LayerInfos() {
+		blobs = append(blobs, layer.BlobInfo)
+	}
+	for _, blob := range blobs {
+		if blob.Digest == "" || o.hasBlob(blob.Digest) {
+			continue
+		}
+		r, _, err := c.source.GetBlob(c.ctx, blob, none.NoCache)
+		if err != nil {
+			return false, fmt.Errorf("get blob %s error: %+v", blob.Digest, err)
+		}
+		err = o.writeBlob(blob.Digest, r)
+		r.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if !o.hasBlob(manifestDigest) {
+		if err = o.writeBlob(manifestDigest, strings.NewReader(string(manifestBytes))); err != nil {
+			return false, err
+		}
+	}
+	o.addManifest(specsv1.Descriptor{
+		MediaType: manifestType,
+		Digest:    manifestDigest,
+		Size:      int64(len(manifestBytes)),
+	}, tag)
+	return true, nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// generateKey writes the public key of a new ECDSA keypair, like `cosign generate-key-pair`
+func generateKey(t *testing.T, dir, name string) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+".pub")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, path
+}
+
+// pushSignature pushes a cosign signature of the manifest digest made by the key
+func pushSignature(t *testing.T, reg *registrytest.Registry, repo string, key *ecdsa.PrivateKey, signed digest.Digest) {
+	t.Helper()
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s/%s"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`,
+		reg.Host(), repo, signed))
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := []byte(`{"architecture":"","os":"","config":{},"rootfs":{"type":"layers","diff_ids":[]}}`)
+	m, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     specsv1.MediaTypeImageManifest,
+		"config":        specsv1.Descriptor{MediaType: specsv1.MediaTypeImageConfig, Digest: reg.PushBlob(repo, config), Size: int64(len(config))},
+		"layers": []specsv1.Descriptor{{
+			MediaType:   SimpleSigningMediaType,
+			Digest:      reg.PushBlob(repo, payload),
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(signature)},
+		}},
+	})
+	reg.PushManifest(repo, cosignTag(signed, signatureTagSuffix), specsv1.MediaTypeImageManifest, m)
+}
+
+func TestSaveVerifyKey(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"a": "b"}))
+	signedIndex := reg.PushIndex("team/signed", "1.0", img)
+	reg.PushIndex("team/unsigned", "1.0", img)
+
+	key, keyPath := generateKey(t, dir, "cosign")
+	_, otherKeyPath := generateKey(t, dir, "other")
+	pushSignature(t, reg, "team/signed", key, signedIndex)
+
+	publicKey, err := LoadPublicKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, err := LoadPublicKey(otherKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newTestClient(t, reg, "team/signed:1.0", "", "").Save(nil, []string{"amd64"}, filepath.Join(dir, "signed.tgz"), &SaveOptions{VerifyKey: publicKey})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+
+	_, err = newTestClient(t, reg, "team/signed:1.0", "", "").Save(nil, []string{"amd64"}, filepath.Join(dir, "other.tgz"), &SaveOptions{VerifyKey: otherPublicKey})
+	if err == nil || !strings.Contains(err.Error(), "no valid signature") {
+		t.Errorf("expected a signature error, got %+v", err)
+	}
+
+	before := len(reg.Requests())
+	_, err = newTestClient(t, reg, "team/unsigned:1.0", "", "").Save(nil, []string{"amd64"}, filepath.Join(dir, "unsigned.tgz"), &SaveOptions{VerifyKey: publicKey})
+	if err == nil {
+		t.Fatalf("expected a signature error")
+	}
+	for _, req := range reg.Requests()[before:] {
+		if strings.Contains(req, "/blobs/") {
+			t.Errorf("blob downloaded before the signature was verified: %s", req)
+		}
+	}
+}
+
+func TestSaveArtifacts(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"a": "b"}))
+	signed := reg.PushImage("team/app", "1.0", img)
+	key, _ := generateKey(t, dir, "cosign")
+	pushSignature(t, reg, "team/app", key, signed)
+
+	// an SBOM attached with the referrers API
+	sbom := []byte(`{"spdxVersion":"SPDX-2.3"}`)
+	config := []byte(`{}`)
+	referrer, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     specsv1.MediaTypeImageManifest,
+		"artifactType":  "application/spdx+json",
+		"config":        specsv1.Descriptor{MediaType: "application/vnd.oci.empty.v1+json", Digest: reg.PushBlob("team/app", config), Size: 2},
+		"layers":        []specsv1.Descriptor{{MediaType: "application/spdx+json", Digest: reg.PushBlob("team/app", sbom), Size: int64(len(sbom))}},
+		"subject":       specsv1.Descriptor{MediaType: img.MediaType, Digest: signed, Size: int64(len(img.Manifest))},
+	})
+	referrerDigest := reg.PushManifest("team/app", "", specsv1.MediaTypeImageManifest, referrer)
+
+	output := filepath.Join(dir, "app.tgz")
+	report, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output, &SaveOptions{Artifacts: true})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if report.Images[0].Artifacts != 2 {
+		t.Errorf("expected 2 artifacts, got %d", report.Images[0].Artifacts)
+	}
+
+	a := openArchive(t, output)
+	for _, d := range []digest.Digest{referrerDigest, digest.FromBytes(sbom)} {
+		if _, ok := a.Files[fmt.Sprintf("%s/blobs/sha256/%s", ArtifactsDir, d.Encoded())]; !ok {
+			t.Errorf("blob %s missing from the OCI layout", d)
+		}
+	}
+	for _, f := range []string{"oci-layout", "index.json"} {
+		if _, ok := a.Files[fmt.Sprintf("%s/%s", ArtifactsDir, f)]; !ok {
+			t.Errorf("%s missing from the OCI layout", f)
+		}
+	}
+}
+
+func TestSaveArtifactsRegistryError(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"a": "b"})))
+
+	// only a missing tag means there is no artifact, a failing registry is an error
+	reg.InjectFault(signatureTagSuffix, http.StatusInternalServerError, 10)
+	output := filepath.Join(dir, "app.tgz")
+	_, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output, &SaveOptions{Artifacts: true})
+	if err == nil || !strings.Contains(err.Error(), signatureTagSuffix) {
+		t.Errorf("expected an error getting the signature, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/rootfs"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// squashCreatedBy is the history entry of the layer written by --squash
+const squashCreatedBy = "imsave --squash"
+
+// squash merges the downloaded layers into a single uncompressed layer, honouring the whiteouts,
+// and returns it with the config rewritten for it
+func (w *archiveWriter) squash(config []byte, layers []manifest.LayerInfo) ([]byte, manifest.LayerInfo, error) {
+	var sources []rootfs.Layer
+	for _, layer := range layers {
+		file, ok := w.blobs[layer.Digest]
+		if !ok {
+			return nil, manifest.LayerInfo{}, fmt.Errorf("layer %s was not downloaded, foreign layers cannot be squashed", layer.Digest)
+		}
+		path := filepath.Join(w.dir, file)
+		sources = append(sources, func() (io.ReadCloser, error) {
+			return openLayer(path)
+		})
+	}
+
+	f, err := os.CreateTemp(w.dir, "squash-*"+blobSuffix)
+	if err != nil {
+		return nil, manifest.LayerInfo{}, fmt.Errorf("create squashed layer error: %+v", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	counter := &countWriter{}
+	err = rootfs.WriteTar(io.MultiWriter(f, h, counter), sources)
+	if err != nil {
+		return nil, manifest.LayerInfo{}, fmt.Errorf("squash layers error: %+v", err)
+	}
+	if err = f.Close(); err != nil {
+		return nil, manifest.LayerInfo{}, fmt.Errorf("write squashed layer error: %+v", err)
+	}
+
+	// the layer is not compressed, its digest is its diff_id
+	diffID := digest.NewDigest(digest.SHA256, h)
+	if _, ok := w.blobs[diffID]; !ok {
+		w.blobs[diffID] = filepath.Base(f.Name())
+	} else {
+		os.Remove(f.Name())
+	}
+	layer := manifest.LayerInfo{BlobInfo: types.BlobInfo{
+		Digest:    diffID,
+		Size:      counter.n,
+		MediaType: manifest.DockerV2SchemaLayerMediaTypeUncompressed,
+	}}
+
+	config, err = squashConfig(config, diffID, len(layers))
+	if err != nil {
+		return nil, manifest.LayerInfo{}, err
+	}
+	return config, layer, nil
+}
+
+// squashConfig points the config to the squashed layer, the history entries are kept as empty layers
+// and an entry is added for the squashed layer
+func squashConfig(config []byte, diffID digest.Digest, squashed int) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(config, &fields); err != nil {
+		return nil, fmt.Errorf("parse config error: %+v", err)
+	}
+	var image struct {
+		Created *time.Time        `json:"created"`
+		RootFS  specsv1.RootFS    `json:"rootfs"`
+		History []specsv1.History `json:"history"`
+	}
+	if err := json.Unmarshal(config, &image); err != nil {
+		return nil, fmt.Errorf("parse config error: %+v", err)
+	}
+
+	for i := range image.History {
+		image.History[i].EmptyLayer = true
+	}
+	image.History = append(image.History, specsv1.History{
+		// the creation time of the image keeps the output reproducible
+		Created:   image.Created,
+		CreatedBy: squashCreatedBy,
+		Comment:   fmt.Sprintf("squashed %d layers", squashed),
+	})
+	image.RootFS.DiffIDs = []digest.Digest{diffID}
+
+	var err error
+	if fields["rootfs"], err = json.Marshal(image.RootFS); err != nil {
+		return nil, fmt.Errorf("marshal rootfs error: %+v", err)
+	}
+	if fields["history"], err = json.Marshal(image.History); err != nil {
+		return nil, fmt.Errorf("marshal history error: %+v", err)
+	}
+	config, err = json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config error: %+v", err)
+	}
+	return config, nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
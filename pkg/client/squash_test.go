@@ -0,0 +1,77 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveSquash(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	img := registrytest.NewImage("linux/amd64",
+		registrytest.FileLayer(map[string]string{"etc/os-release": "test", "tmp/build.log": "log"}),
+		registrytest.Layer(registrytest.File{Name: "tmp/.wh.build.log"}, registrytest.File{Name: "app/run.sh", Content: "echo hello"}))
+	reg.PushImage("team/app", "1.0", img)
+
+	output := filepath.Join(dir, "app.tgz")
+	report, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output, &SaveOptions{Squash: true})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if len(report.Images[0].Layers) != 1 {
+		t.Errorf("unexpected report: %+v", report.Images[0].Layers)
+	}
+
+	a := openArchive(t, output)
+	verifyArchive(t, a)
+	if len(a.Manifest[0].Layers) != 1 {
+		t.Fatalf("layers were not squashed: %+v", a.Manifest[0])
+	}
+	history := configOf(t, a, 0)["history"].([]interface{})
+	last := history[len(history)-1].(map[string]interface{})
+	if len(history) != 3 || last["created_by"] != squashCreatedBy || last["empty_layer"] != nil {
+		t.Errorf("unexpected history: %v", history)
+	}
+
+	var names []string
+	a.Walk(func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name != a.Manifest[0].Layers[0] {
+			return nil
+		}
+		content, _ := io.ReadAll(r)
+		tr := tar.NewReader(bytes.NewReader(content))
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				return nil
+			}
+			names = append(names, hdr.Name)
+		}
+	})
+	if !reflect.DeepEqual(names, []string{"etc/os-release", "app/run.sh"}) {
+		t.Errorf("unexpected squashed layer: %v", names)
+	}
+}
+
+func TestSaveSquashZstd(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	reg.PushImage("team/app", "1.0", registrytest.NewOCIZstdImage("linux/amd64",
+		registrytest.ZstdLayer(registrytest.FileLayer(map[string]string{"etc/os-release": "test"})),
+		registrytest.ZstdLayer(registrytest.FileLayer(map[string]string{"app/run.sh": "echo hello"}))))
+
+	output := filepath.Join(dir, "app.tgz")
+	if _, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output, &SaveOptions{Squash: true}); err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	a := openArchive(t, output)
+	verifyArchive(t, a)
+	if len(a.Manifest[0].Layers) != 1 {
+		t.Errorf("layers were not squashed: %+v", a.Manifest[0])
+	}
+}
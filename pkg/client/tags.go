@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/containers/image/v5/docker"
+	"regexp"
+	"sort"
+)
+
+const (
+	SortNone   = ""
+	SortAlpha  = "alpha"
+	SortSemver = "semver"
+)
+
+// TagFilter selects tags of a repository, a zero value keeps every tag
+type TagFilter struct {
+	// Regex is matched against the tag name
+	Regex string
+	// Constraint is a semver range like ">=1.2, <2", tags which are not a semver are dropped
+	Constraint string
+	// Sort is one of SortNone, SortAlpha or SortSemver (newest first)
+	Sort string
+	// Limit keeps the first n tags after sorting, 0 means unlimited
+	Limit int
+}
+
+// SelectsTags reports whether the filter picks tags by name, Sort and Limit only order and cut the picked tags
+func (f *TagFilter) SelectsTags() bool {
+	return f.Regex != "" || f.Constraint != ""
+}
+
+// ListTags lists every tag of the repository, following the registry pagination
+func (c *Client) ListTags() ([]string, error) {
+	err := c.initReference()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := docker.GetRepositoryTags(c.ctx, c.sysContext, c.sourceRef)
+	if err != nil {
+		return nil, fmt.Errorf("list tags of %s error: %+v", c.sourceRef.DockerReference().Name(), err)
+	}
+	return tags, nil
+}
+
+// Apply filters, sorts and limits the tags
+func (f *TagFilter) Apply(tags []string) ([]string, error) {
+	var re *regexp.Regexp
+	if f.Regex != "" {
+		var err error
+		re, err = regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag regex[%s]: %+v", f.Regex, err)
+		}
+	}
+
+	var constraint *semver.Constraints
+	if f.Constraint != "" {
+		var err error
+		constraint, err = semver.NewConstraint(f.Constraint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver constraint[%s]: %+v", f.Constraint, err)
+		}
+	}
+
+	if f.Sort != SortNone && f.Sort != SortAlpha && f.Sort != SortSemver {
+		return nil, fmt.Errorf("unsupported tag sort: %s", f.Sort)
+	}
+
+	versions := make(map[string]*semver.Version)
+	var res []string
+	for _, tag := range tags {
+		if re != nil && !re.MatchString(tag) {
+			continue
+		}
+		if constraint != nil || f.Sort == SortSemver {
+			v, err := semver.NewVersion(tag)
+			if err != nil {
+				continue
+			}
+			if constraint != nil && !constraint.Check(v) {
+				continue
+			}
+			versions[tag] = v
+		}
+		res = append(res, tag)
+	}
+
+	switch f.Sort {
+	case SortAlpha:
+		sort.Strings(res)
+	case SortSemver:
+		sort.SliceStable(res, func(i, j int) bool {
+			return versions[res[i]].GreaterThan(versions[res[j]])
+		})
+	}
+
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+	}
+	return res, nil
+}
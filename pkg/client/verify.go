@@ -0,0 +1,145 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	SignatureAnnotation    = "dev.cosignproject.cosign/signature"
+)
+
+// SimpleSigning is the payload signed by cosign
+type SimpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// PublicKey verifies cosign signatures offline
+type PublicKey struct {
+	key crypto.PublicKey
+}
+
+// LoadPublicKey reads a PEM encoded ECDSA, RSA or ed25519 public key as written by `cosign generate-key-pair`
+func LoadPublicKey(path string) (*PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key %s error: %+v", path, err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: parse public key error: %+v", path, err)
+	}
+	return &PublicKey{key: key}, nil
+}
+
+// Verify checks the signature of the simple signing payload and that it was made for the manifest digest
+func (k *PublicKey) Verify(payload, signature []byte, manifestDigest digest.Digest) error {
+	hash := sha256.Sum256(payload)
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+			return fmt.Errorf("invalid signature: %+v", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", k.key)
+	}
+
+	var s SimpleSigning
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return fmt.Errorf("parse signed payload error: %+v", err)
+	}
+	if s.Critical.Image.DockerManifestDigest != manifestDigest {
+		return fmt.Errorf("signature is for %s", s.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+// verifySignature looks for a cosign signature of one of the manifest digests made by the key,
+// it returns the digest which is signed
+func (c *Client) verifySignature(key *PublicKey, digests []digest.Digest) (digest.Digest, error) {
+	var reasons []string
+	for _, d := range digests {
+		tag := cosignTag(d, signatureTagSuffix)
+		manifestBytes, _, err := c.getTaggedManifest(tag)
+		if err != nil {
+			logrus.Debugf("get manifest of %s error: %+v", tag, err)
+			reasons = append(reasons, fmt.Sprintf("%s: no signature", d))
+			continue
+		}
+
+		var m specsv1.Manifest
+		if err = json.Unmarshal(manifestBytes, &m); err != nil {
+			return "", fmt.Errorf("parse signature manifest %s error: %+v", tag, err)
+		}
+
+		for _, layer := range m.Layers {
+			if layer.MediaType != SimpleSigningMediaType {
+				continue
+			}
+			signature, err := base64.StdEncoding.DecodeString(layer.Annotations[SignatureAnnotation])
+			if err != nil || len(signature) == 0 {
+				reasons = append(reasons, fmt.Sprintf("%s: layer %s has no valid signature annotation", tag, layer.Digest))
+				continue
+			}
+
+			blob, _, err := c.source.GetBlob(c.ctx, types.BlobInfo{Digest: layer.Digest, Size: layer.Size}, none.NoCache)
+			if err != nil {
+				return "", fmt.Errorf("get signature payload %s error: %+v", layer.Digest, err)
+			}
+			payload, err := io.ReadAll(blob)
+			blob.Close()
+			if err != nil {
+				return "", fmt.Errorf("read signature payload %s error: %+v", layer.Digest, err)
+			}
+			if actual := digest.FromBytes(payload); actual != layer.Digest {
+				reasons = append(reasons, fmt.Sprintf("%s: payload %s has digest %s", tag, layer.Digest, actual))
+				continue
+			}
+
+			if err = key.Verify(payload, signature, d); err != nil {
+				reasons = append(reasons, fmt.Sprintf("%s: %+v", tag, err))
+				continue
+			}
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no valid signature found (%s)", c.repo.url, strings.Join(reasons, "; "))
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveSplitSize(t *testing.T) {
+	dir := chdirTemp(t)
+	reg := newTestRegistry(t)
+	// random data does not compress into a single volume
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+	reg.PushImage("team/app", "1.0", registrytest.NewImage("linux/amd64", registrytest.FileLayer(map[string]string{"data": string(data)})))
+
+	output := filepath.Join(dir, "app.tgz")
+	report, err := newTestClient(t, reg, "team/app:1.0", "", "").Save(nil, []string{"amd64"}, output, &SaveOptions{SplitSize: 1000})
+	if err != nil {
+		t.Fatalf("save error: %+v", err)
+	}
+	if len(report.Volumes) < 2 || report.Volumes[0].Name != "app.tgz.001" {
+		t.Fatalf("unexpected volumes: %+v", report.Volumes)
+	}
+	var size int64
+	for _, part := range report.Volumes {
+		if part.Size > 1000 {
+			t.Errorf("volume %s is %d bytes", part.Name, part.Size)
+		}
+		size += part.Size
+	}
+	if size != report.Size {
+		t.Errorf("volumes hold %d bytes instead of %d", size, report.Size)
+	}
+	if _, err = os.Stat(output); err == nil {
+		t.Errorf("%s was kept", output)
+	}
+
+	// the archive opens by its name, its first volume or the list of volumes
+	for _, path := range []string{output, output + ".001", output + archive.VolumesSuffix} {
+		a := openArchive(t, path)
+		verifyArchive(t, a)
+		if a.Volumes == nil || len(a.Volumes.Parts) != len(report.Volumes) {
+			t.Errorf("%s: unexpected volumes %+v", path, a.Volumes)
+		}
+	}
+
+	list, _ := os.ReadFile(output + archive.VolumesSuffix)
+	os.WriteFile(output+archive.VolumesSuffix, []byte(strings.Replace(string(list), report.Volumes[0].Sha256, strings.Repeat("0", 64), 1)), 0644)
+	problems, err := openArchive(t, output).Verify()
+	if err != nil || len(problems) != 1 || !strings.Contains(problems[0], "app.tgz.001") {
+		t.Errorf("unexpected problems: %v %+v", problems, err)
+	}
+}
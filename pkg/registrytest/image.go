@@ -0,0 +1,290 @@
+package registrytest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/archive"
+	"github.com/containers/image/v5/manifest"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// File is an entry of a test layer, an empty Content with a trailing / in Name is a directory
+type File struct {
+	Name     string
+	Content  string
+	Mode     int64
+	Linkname string
+	Typeflag byte
+	Uid, Gid int
+}
+
+// Layer builds a gzip compressed tar layer holding the files, in the given order
+func Layer(files ...File) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:     f.Name,
+			Mode:     f.Mode,
+			Size:     int64(len(f.Content)),
+			Linkname: f.Linkname,
+			Typeflag: f.Typeflag,
+			Uid:      f.Uid,
+			Gid:      f.Gid,
+			ModTime:  time.Unix(0, 0),
+		}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+			if strings.HasSuffix(f.Name, "/") {
+				hdr.Typeflag = tar.TypeDir
+			}
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Mode = 0755
+			}
+		}
+		tw.WriteHeader(hdr)
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(f.Content))
+		}
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+// FileLayer builds a layer from a map of file names to content, sorted by name
+func FileLayer(files map[string]string) []byte {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var entries []File
+	for _, name := range names {
+		entries = append(entries, File{Name: name, Content: files[name]})
+	}
+	return Layer(entries...)
+}
+
+// ZstdLayer recompresses a layer built by Layer or FileLayer with zstd
+func ZstdLayer(layer []byte) []byte {
+	gr, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		panic(err)
+	}
+	content, err := io.ReadAll(gr)
+	if err != nil {
+		panic(err)
+	}
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic(err)
+	}
+	return zw.EncodeAll(content, nil)
+}
+
+// Image is a single platform image ready to be pushed
+type Image struct {
+	MediaType string
+	Manifest  []byte
+	Config    []byte
+	Layers    [][]byte
+	Platform  specsv1.Platform
+}
+
+// Digest returns the digest of the manifest
+func (i *Image) Digest() digest.Digest {
+	return digest.FromBytes(i.Manifest)
+}
+
+// NewImage builds a docker schema2 image for the platform (os/arch[/variant]) with a history entry per layer
+func NewImage(platform string, layers ...[]byte) *Image {
+	return newImage(manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema2ConfigMediaType, manifest.DockerV2Schema2LayerMediaType, platform, layers)
+}
+
+// NewOCIImage builds an OCI image for the platform (os/arch[/variant]) with a history entry per layer
+func NewOCIImage(platform string, layers ...[]byte) *Image {
+	return newImage(specsv1.MediaTypeImageManifest, specsv1.MediaTypeImageConfig, specsv1.MediaTypeImageLayerGzip, platform, layers)
+}
+
+// NewOCIZstdImage builds an OCI image of zstd compressed layers, see ZstdLayer
+func NewOCIZstdImage(platform string, layers ...[]byte) *Image {
+	return newImage(specsv1.MediaTypeImageManifest, specsv1.MediaTypeImageConfig, specsv1.MediaTypeImageLayerZstd, platform, layers)
+}
+
+// NewSchema1Image builds an unsigned docker schema1 image for the architecture, a throwaway CMD entry
+// without content is added on top of the layers like older docker versions did
+func NewSchema1Image(arch string, layers ...[]byte) *Image {
+	empty := Layer()
+	type v1Compatibility struct {
+		ID              string    `json:"id"`
+		Parent          string    `json:"parent,omitempty"`
+		Created         time.Time `json:"created"`
+		Architecture    string    `json:"architecture,omitempty"`
+		OS              string    `json:"os,omitempty"`
+		ThrowAway       bool      `json:"throwaway,omitempty"`
+		ContainerConfig struct {
+			Cmd []string `json:"Cmd"`
+		} `json:"container_config"`
+		Config *specsv1.ImageConfig `json:"config,omitempty"`
+	}
+
+	var fsLayers []manifest.Schema1FSLayers
+	var history []manifest.Schema1History
+	parent := ""
+	created := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	blobs := append(append([][]byte{}, layers...), empty)
+	for i, layer := range blobs {
+		v1 := v1Compatibility{
+			ID:      digest.FromString(fmt.Sprintf("%s-%d", digest.FromBytes(layer), i)).Encoded(),
+			Parent:  parent,
+			Created: created.Add(time.Duration(i) * time.Minute),
+		}
+		v1.ContainerConfig.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) ADD layer%d in /", i)}
+		if i == len(blobs)-1 {
+			v1.ThrowAway = true
+			v1.Architecture = arch
+			v1.OS = "linux"
+			v1.ContainerConfig.Cmd = []string{"/bin/sh", "-c", `#(nop) CMD ["/bin/sh"]`}
+			v1.Config = &specsv1.ImageConfig{Cmd: []string{"/bin/sh"}}
+		}
+		parent = v1.ID
+		compat, _ := json.Marshal(v1)
+		// schema1 lists the top layer first
+		fsLayers = append([]manifest.Schema1FSLayers{{BlobSum: digest.FromBytes(layer)}}, fsLayers...)
+		history = append([]manifest.Schema1History{{V1Compatibility: string(compat)}}, history...)
+	}
+
+	m, err := manifest.Schema1FromComponents(nil, fsLayers, history, arch)
+	if err != nil {
+		panic(err)
+	}
+	manifestBytes, err := m.Serialize()
+	if err != nil {
+		panic(err)
+	}
+	return &Image{
+		MediaType: manifest.DockerV2Schema1MediaType,
+		Manifest:  manifestBytes,
+		Layers:    blobs,
+		Platform:  specsv1.Platform{OS: "linux", Architecture: arch},
+	}
+}
+
+func newImage(mediaType, configType, layerType, platform string, layers [][]byte) *Image {
+	parts := strings.SplitN(platform, "/", 3)
+	p := specsv1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	created := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	config := specsv1.Image{
+		Created:      &created,
+		OS:           p.OS,
+		Architecture: p.Architecture,
+		Variant:      p.Variant,
+		Config: specsv1.ImageConfig{
+			Env: []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+			Cmd: []string{"/bin/sh"},
+		},
+		RootFS: specsv1.RootFS{Type: "layers"},
+	}
+
+	var layerDescriptors []specsv1.Descriptor
+	for i, layer := range layers {
+		diffID, err := diffID(layer)
+		if err != nil {
+			panic(err)
+		}
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		at := created.Add(time.Duration(i) * time.Minute)
+		config.History = append(config.History, specsv1.History{
+			Created:   &at,
+			CreatedBy: fmt.Sprintf("/bin/sh -c #(nop) ADD layer%d in /", i),
+		})
+		layerDescriptors = append(layerDescriptors, specsv1.Descriptor{
+			MediaType: layerType,
+			Digest:    digest.FromBytes(layer),
+			Size:      int64(len(layer)),
+		})
+	}
+
+	configBytes, _ := json.Marshal(config)
+	m := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaType,
+		"config": specsv1.Descriptor{
+			MediaType: configType,
+			Digest:    digest.FromBytes(configBytes),
+			Size:      int64(len(configBytes)),
+		},
+		"layers": layerDescriptors,
+	}
+	manifestBytes, _ := json.Marshal(m)
+
+	return &Image{
+		MediaType: mediaType,
+		Manifest:  manifestBytes,
+		Config:    configBytes,
+		Layers:    layers,
+		Platform:  p,
+	}
+}
+
+func diffID(layer []byte) (digest.Digest, error) {
+	return archive.DiffID(bytes.NewReader(layer))
+}
+
+// PushImage pushes the blobs and the manifest of the image and tags it, schema1 images have no config
+func (r *Registry) PushImage(repo, tag string, img *Image) digest.Digest {
+	if img.Config != nil {
+		r.PushBlob(repo, img.Config)
+	}
+	for _, layer := range img.Layers {
+		r.PushBlob(repo, layer)
+	}
+	return r.PushManifest(repo, tag, img.MediaType, img.Manifest)
+}
+
+// PushIndex pushes the images and a manifest list referencing them, an OCI index when the images are OCI ones
+func (r *Registry) PushIndex(repo, tag string, images ...*Image) digest.Digest {
+	mediaType := manifest.DockerV2ListMediaType
+	if len(images) > 0 && images[0].MediaType == specsv1.MediaTypeImageManifest {
+		mediaType = specsv1.MediaTypeImageIndex
+	}
+
+	var descriptors []specsv1.Descriptor
+	for _, img := range images {
+		platform := img.Platform
+		descriptors = append(descriptors, specsv1.Descriptor{
+			MediaType: img.MediaType,
+			Digest:    r.PushImage(repo, "", img),
+			Size:      int64(len(img.Manifest)),
+			Platform:  &platform,
+		})
+	}
+
+	index, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaType,
+		"manifests":     descriptors,
+	})
+	return r.PushManifest(repo, tag, mediaType, index)
+}
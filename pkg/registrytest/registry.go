@@ -0,0 +1,330 @@
+// Package registrytest provides an in-memory OCI distribution registry for hermetic tests
+package registrytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type manifestEntry struct {
+	mediaType string
+	content   []byte
+}
+
+type fault struct {
+	path   string
+	status int
+	count  int
+}
+
+// Registry serves the pull side of the distribution API from memory
+type Registry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string]map[string]*manifestEntry
+	blobs     map[string]map[digest.Digest][]byte
+	faults    []*fault
+	requests  []string
+
+	// PageSize limits the number of tags returned per page of the tags list, 0 means unlimited
+	PageSize int
+	// Referrers enables the OCI 1.1 referrers API
+	Referrers bool
+
+	username, password string
+	token              string
+}
+
+// New starts a registry listening on a local plain http port, clients have to use it as an insecure registry
+func New() *Registry {
+	r := &Registry{
+		manifests: make(map[string]map[string]*manifestEntry),
+		blobs:     make(map[string]map[digest.Digest][]byte),
+		Referrers: true,
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.handle))
+	return r
+}
+
+// Host returns the host:port of the registry, to be used as the registry part of image references
+func (r *Registry) Host() string {
+	u, _ := url.Parse(r.server.URL)
+	return u.Host
+}
+
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+// EnableTokenAuth requires a bearer token issued by the /token endpoint of the registry,
+// the token endpoint checks the credentials when username is not empty
+func (r *Registry) EnableTokenAuth(username, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.username = username
+	r.password = password
+	r.token = fmt.Sprintf("token-%s", digest.FromString(username + ":" + password).Encoded()[:16])
+}
+
+// InjectFault answers the next count requests whose path contains the given string with status
+func (r *Registry) InjectFault(path string, status, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = append(r.faults, &fault{path: path, status: status, count: count})
+}
+
+// Requests returns the method and path of every request received so far
+func (r *Registry) Requests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.requests...)
+}
+
+// PushBlob stores a blob in the repository and returns its digest
+func (r *Registry) PushBlob(repo string, content []byte) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := digest.FromBytes(content)
+	if _, ok := r.blobs[repo]; !ok {
+		r.blobs[repo] = make(map[digest.Digest][]byte)
+	}
+	r.blobs[repo][d] = content
+	return d
+}
+
+// ReplaceBlob serves content for the blob d of the repository, like a mirror tampering with the images
+func (r *Registry) ReplaceBlob(repo string, d digest.Digest, content []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[repo][d] = content
+}
+
+// PushManifest stores a manifest under its digest and, if not empty, the tag
+func (r *Registry) PushManifest(repo, tag, mediaType string, content []byte) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := digest.FromBytes(content)
+	if _, ok := r.manifests[repo]; !ok {
+		r.manifests[repo] = make(map[string]*manifestEntry)
+	}
+	entry := &manifestEntry{mediaType: mediaType, content: content}
+	r.manifests[repo][d.String()] = entry
+	if tag != "" {
+		r.manifests[repo][tag] = entry
+	}
+	return d
+}
+
+// Tag points a tag to an already pushed manifest
+func (r *Registry) Tag(repo, tag string, d digest.Digest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifests[repo][tag] = r.manifests[repo][d.String()]
+}
+
+func (r *Registry) handle(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, fmt.Sprintf("%s %s", req.Method, req.URL.Path))
+	for _, f := range r.faults {
+		if f.count > 0 && strings.Contains(req.URL.Path, f.path) {
+			f.count--
+			r.mu.Unlock()
+			if f.status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			http.Error(w, http.StatusText(f.status), f.status)
+			return
+		}
+	}
+	token := r.token
+	r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		r.handleToken(w, req)
+		return
+	}
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "read only registry", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if token != "" && req.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"`, r.server.URL))
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	if path == "" {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	for _, route := range []struct {
+		sep     string
+		handler func(http.ResponseWriter, *http.Request, string, string)
+	}{
+		{"/manifests/", r.handleManifest},
+		{"/blobs/", r.handleBlob},
+		{"/referrers/", r.handleReferrers},
+		{"/tags/list", r.handleTags},
+	} {
+		if i := strings.LastIndex(path, route.sep); i > 0 {
+			route.handler(w, req, path[:i], path[i+len(route.sep):])
+			return
+		}
+	}
+	http.NotFound(w, req)
+}
+
+func (r *Registry) handleToken(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	username, password, token := r.username, r.password, r.token
+	r.mu.Unlock()
+
+	if username != "" {
+		u, p, ok := req.BasicAuth()
+		if !ok || u != username || p != password {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func (r *Registry) handleManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	r.mu.Lock()
+	entry := r.manifests[repo][ref]
+	r.mu.Unlock()
+	if entry == nil {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+	w.Header().Set("Content-Type", entry.mediaType)
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(entry.content).String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.content)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(entry.content)
+	}
+}
+
+func (r *Registry) handleBlob(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	r.mu.Lock()
+	content, ok := r.blobs[repo][digest.Digest(ref)]
+	r.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", ref)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (r *Registry) handleReferrers(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	if !r.Referrers {
+		http.NotFound(w, req)
+		return
+	}
+
+	index := specsv1.Index{MediaType: specsv1.MediaTypeImageIndex, Manifests: []specsv1.Descriptor{}}
+	index.SchemaVersion = 2
+
+	r.mu.Lock()
+	for key, entry := range r.manifests[repo] {
+		if !strings.Contains(key, ":") {
+			// skip tags, every manifest is stored under its digest too
+			continue
+		}
+		var m struct {
+			ArtifactType string              `json:"artifactType"`
+			Config       specsv1.Descriptor  `json:"config"`
+			Subject      *specsv1.Descriptor `json:"subject"`
+			Annotations  map[string]string   `json:"annotations"`
+		}
+		if json.Unmarshal(entry.content, &m) != nil || m.Subject == nil || m.Subject.Digest.String() != ref {
+			continue
+		}
+		artifactType := m.ArtifactType
+		if artifactType == "" {
+			artifactType = m.Config.MediaType
+		}
+		index.Manifests = append(index.Manifests, specsv1.Descriptor{
+			MediaType:    entry.mediaType,
+			ArtifactType: artifactType,
+			Digest:       digest.Digest(key),
+			Size:         int64(len(entry.content)),
+			Annotations:  m.Annotations,
+		})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(index.Manifests, func(i, j int) bool { return index.Manifests[i].Digest < index.Manifests[j].Digest })
+	w.Header().Set("Content-Type", specsv1.MediaTypeImageIndex)
+	json.NewEncoder(w).Encode(index)
+}
+
+func (r *Registry) handleTags(w http.ResponseWriter, req *http.Request, repo, _ string) {
+	r.mu.Lock()
+	var tags []string
+	for key := range r.manifests[repo] {
+		if !strings.Contains(key, ":") {
+			tags = append(tags, key)
+		}
+	}
+	pageSize := r.PageSize
+	r.mu.Unlock()
+
+	if tags == nil {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	sort.Strings(tags)
+
+	if last := req.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	if n, err := strconv.Atoi(req.URL.Query().Get("n")); err == nil && n > 0 && (pageSize == 0 || n < pageSize) {
+		pageSize = n
+	}
+	if pageSize > 0 && len(tags) > pageSize {
+		tags = tags[:pageSize]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=%d&last=%s>; rel="next"`, repo, pageSize, url.QueryEscape(tags[len(tags)-1])))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags})
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,278 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSymlinks bounds the symlinks followed to resolve a path, like the kernel does
+const maxSymlinks = 255
+
+// dirAttrs are the mode and mtime of a directory, applied once its children are extracted
+type dirAttrs struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// ExtractDir applies the layers in order onto dir. Ownership and device files need root privileges,
+// they are skipped with a warning otherwise.
+func ExtractDir(dir string, layers []Layer) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("create %s error: %+v", dir, err)
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	e := &dirExtractor{root: root, privileged: os.Geteuid() == 0, dirIndex: make(map[string]int)}
+	if !e.privileged {
+		logrus.Warnf("not running as root, file ownership and device files are not restored")
+	}
+	for i, layer := range layers {
+		r, err := layer()
+		if err != nil {
+			return err
+		}
+		err = e.apply(tar.NewReader(r))
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("layer %d: %+v", i, err)
+		}
+	}
+
+	// directories get their modes and times last, deepest first: a read-only directory would reject
+	// its children and adding entries below a directory changes its mtime
+	sort.SliceStable(e.dirs, func(i, j int) bool {
+		return strings.Count(e.dirs[i].path, string(filepath.Separator)) > strings.Count(e.dirs[j].path, string(filepath.Separator))
+	})
+	for _, d := range e.dirs {
+		// an upper layer may have removed the directory or replaced it with a file
+		if fi, err := os.Lstat(d.path); err != nil || !fi.IsDir() {
+			continue
+		}
+		if err = os.Chmod(d.path, d.mode); err != nil {
+			return fmt.Errorf("chmod %s error: %+v", d.path, err)
+		}
+		os.Chtimes(d.path, d.mtime, d.mtime)
+	}
+	return nil
+}
+
+type dirExtractor struct {
+	root       string
+	privileged bool
+	dirs       []dirAttrs
+	// dirIndex is the index in dirs of every extracted directory, an upper layer overrides its attributes
+	dirIndex map[string]int
+}
+
+func (e *dirExtractor) apply(tr *tar.Reader) error {
+	// entries of the current layer, an opaque whiteout only hides the lower layers
+	created := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read layer error: %+v", err)
+		}
+
+		name, err := cleanPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+
+		parent, err := e.resolve(path.Dir(name))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(parent, path.Base(name))
+
+		if removed, opaque := whiteout(name); removed != "" {
+			if opaque {
+				err = e.clearDir(parent, created)
+			} else {
+				err = os.RemoveAll(filepath.Join(parent, path.Base(removed)))
+			}
+			if err != nil {
+				return fmt.Errorf("apply whiteout %s error: %+v", name, err)
+			}
+			continue
+		}
+
+		err = e.extract(hdr, name, parent, target, tr)
+		if err != nil {
+			return fmt.Errorf("extract %s error: %+v", name, err)
+		}
+		created[target] = true
+	}
+}
+
+// clearDir removes the entries of dir which were not created by the current layer
+func (e *dirExtractor) clearDir(dir string, created map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		if created[p] {
+			continue
+		}
+		if err = os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *dirExtractor) extract(hdr *tar.Header, name, parent, target string, r io.Reader) error {
+	err := os.MkdirAll(parent, 0755)
+	if err != nil {
+		return err
+	}
+
+	// an entry replaces the one of the lower layers, only directories are merged
+	if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err = os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		err = os.Mkdir(target, 0755)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err = os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		linkname, err := cleanPath(hdr.Linkname)
+		if err != nil || linkname == "" {
+			return fmt.Errorf("invalid hardlink target %s", hdr.Linkname)
+		}
+		linkParent, err := e.resolve(path.Dir(linkname))
+		if err != nil {
+			return err
+		}
+		// the link shares the metadata of its target
+		return os.Link(filepath.Join(linkParent, path.Base(linkname)), target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if !e.privileged && hdr.Typeflag != tar.TypeFifo {
+			logrus.Warnf("skip device file %s", name)
+			return nil
+		}
+		if err = mknod(target, hdr); err != nil {
+			return err
+		}
+	default:
+		logrus.Debugf("skip %s of type %c", name, hdr.Typeflag)
+		return nil
+	}
+
+	if e.privileged {
+		if err = os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return setXattrs(target, hdr)
+	}
+	mode := hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if hdr.Typeflag == tar.TypeDir {
+		if err = setXattrs(target, hdr); err != nil {
+			return err
+		}
+		e.addDir(dirAttrs{path: target, mode: mode, mtime: hdr.ModTime})
+		return nil
+	}
+	// chown clears the setuid bits, the mode comes after it
+	if err = os.Chmod(target, mode); err != nil {
+		return err
+	}
+	if err = setXattrs(target, hdr); err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// addDir records the attributes of a directory, they replace those of a lower layer
+func (e *dirExtractor) addDir(d dirAttrs) {
+	if i, ok := e.dirIndex[d.path]; ok {
+		e.dirs[i] = d
+		return
+	}
+	e.dirIndex[d.path] = len(e.dirs)
+	e.dirs = append(e.dirs, d)
+}
+
+// resolve returns the host path of a directory below the root, following the symlinks of the image
+// the way they would be inside a chroot so that no entry is written outside of the root
+func (e *dirExtractor) resolve(name string) (string, error) {
+	current := ""
+	parts := strings.Split(name, "/")
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			current = strings.TrimPrefix(path.Dir("/"+current), "/")
+			continue
+		}
+
+		next := path.Join(current, part)
+		fi, err := os.Lstat(filepath.Join(e.root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks resolving %s", name)
+		}
+		link, err := os.Readlink(filepath.Join(e.root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			current = ""
+		}
+		parts = append(strings.Split(link, "/"), parts...)
+	}
+	return filepath.Join(e.root, current), nil
+}
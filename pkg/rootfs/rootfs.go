@@ -0,0 +1,47 @@
+// Package rootfs flattens the layers of an image into a single root filesystem
+package rootfs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// WhiteoutPrefix marks a file removing the entry of the same name from the lower layers
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaque marks a directory hiding every entry of the lower layers below it
+	WhiteoutOpaque = ".wh..wh..opq"
+
+	xattrPrefix = "SCHILY.xattr."
+)
+
+// Layer opens the uncompressed tar stream of a layer, it may be opened several times
+type Layer func() (io.ReadCloser, error)
+
+// cleanPath returns the path of a tar entry relative to the root, "" for the root itself.
+// Entries escaping the root through .. are rejected.
+func cleanPath(name string) (string, error) {
+	p := path.Clean(strings.TrimLeft(name, "/"))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("entry %s escapes the root", name)
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
+
+// whiteout returns the path removed by a whiteout entry and whether the entry is an opaque whiteout,
+// target is "" for regular entries
+func whiteout(name string) (target string, opaque bool) {
+	dir, base := path.Split(name)
+	if base == WhiteoutOpaque {
+		return path.Clean(dir), true
+	}
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		return path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix)), false
+	}
+	return "", false
+}
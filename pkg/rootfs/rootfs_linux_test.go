@@ -0,0 +1,37 @@
+package rootfs
+
+import (
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestExtractDirOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("ownership is only restored as root")
+	}
+	root := t.TempDir()
+	err := ExtractDir(root, []Layer{layer(
+		registrytest.File{Name: "home/user/", Mode: 0700, Uid: 1000, Gid: 1000},
+		registrytest.File{Name: "usr/bin/passwd", Content: "passwd", Mode: 04755, Uid: 0, Gid: 42},
+	)})
+	if err != nil {
+		t.Fatalf("extract error: %+v", err)
+	}
+
+	for name, owner := range map[string][2]uint32{"home/user": {1000, 1000}, "usr/bin/passwd": {0, 42}} {
+		fi, err := os.Lstat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != owner[0] || st.Gid != owner[1] {
+			t.Errorf("%s is owned by %d:%d", name, st.Uid, st.Gid)
+		}
+	}
+	if fi, _ := os.Stat(filepath.Join(root, "usr/bin/passwd")); fi.Mode()&os.ModeSetuid == 0 {
+		t.Errorf("setuid bit was cleared")
+	}
+}
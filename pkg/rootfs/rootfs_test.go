@@ -0,0 +1,225 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/DockerContainerService/image-save/pkg/registrytest"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func layer(files ...registrytest.File) Layer {
+	content := registrytest.Layer(files...)
+	return func() (io.ReadCloser, error) {
+		return gzip.NewReader(bytes.NewReader(content))
+	}
+}
+
+// overlayLayers replaces a file, removes another one, makes a directory opaque and replaces a directory with a file
+var overlayLayers = []Layer{
+	layer(
+		registrytest.File{Name: "etc/"},
+		registrytest.File{Name: "etc/a", Content: "a0"},
+		registrytest.File{Name: "etc/b", Content: "b0"},
+		registrytest.File{Name: "etc/hard", Typeflag: tar.TypeLink, Linkname: "etc/a"},
+		registrytest.File{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "b"},
+		registrytest.File{Name: "opt/"},
+		registrytest.File{Name: "opt/old", Content: "old"},
+		registrytest.File{Name: "bin/"},
+		registrytest.File{Name: "bin/sh", Content: "sh", Mode: 04755},
+	),
+	layer(
+		registrytest.File{Name: "etc/a", Content: "a1"},
+		registrytest.File{Name: "etc/.wh.b"},
+		registrytest.File{Name: "opt/"},
+		registrytest.File{Name: "opt/.wh..wh..opq"},
+		registrytest.File{Name: "opt/new", Content: "new"},
+		registrytest.File{Name: "bin", Content: "bin"},
+	),
+}
+
+var overlayResult = map[string]string{
+	"etc":      "dir",
+	"etc/a":    "a1",
+	"etc/hard": "a0",
+	"etc/link": "-> b",
+	"opt":      "dir",
+	"opt/new":  "new",
+	"bin":      "bin",
+}
+
+// readTar describes the entries of a tar, hardlinks have the content of their target
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	res := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			res[name] = "dir"
+		case tar.TypeSymlink:
+			res[name] = "-> " + hdr.Linkname
+		case tar.TypeLink:
+			target, ok := res[hdr.Linkname]
+			if !ok {
+				t.Errorf("hardlink %s written before its target %s", name, hdr.Linkname)
+			}
+			res[name] = target
+		default:
+			content, _ := io.ReadAll(tr)
+			res[name] = string(content)
+		}
+	}
+}
+
+// readDir describes the entries below root like readTar
+func readDir(t *testing.T, root string) map[string]string {
+	t.Helper()
+	res := make(map[string]string)
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, _ := filepath.Rel(root, p)
+		switch {
+		case name == ".":
+		case fi.IsDir():
+			res[name] = "dir"
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, _ := os.Readlink(p)
+			res[name] = "-> " + link
+		default:
+			content, _ := os.ReadFile(p)
+			res[name] = string(content)
+		}
+		return nil
+	})
+	return res
+}
+
+func TestWriteTar(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, overlayLayers); err != nil {
+		t.Fatalf("write tar error: %+v", err)
+	}
+	if res := readTar(t, &buf); !reflect.DeepEqual(res, overlayResult) {
+		t.Errorf("unexpected rootfs: %v", res)
+	}
+}
+
+func TestExtractDir(t *testing.T) {
+	root := t.TempDir()
+	if err := ExtractDir(root, overlayLayers); err != nil {
+		t.Fatalf("extract error: %+v", err)
+	}
+	if res := readDir(t, root); !reflect.DeepEqual(res, overlayResult) {
+		t.Errorf("unexpected rootfs: %v", res)
+	}
+}
+
+func TestExtractDirMetadata(t *testing.T) {
+	root := t.TempDir()
+	err := ExtractDir(root, []Layer{layer(
+		registrytest.File{Name: "usr/bin/su", Content: "su", Mode: 04755},
+		registrytest.File{Name: "home/user/", Mode: 0700},
+	)})
+	if err != nil {
+		t.Fatalf("extract error: %+v", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(root, "usr/bin/su"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0755|os.ModeSetuid {
+		t.Errorf("unexpected mode %v", fi.Mode())
+	}
+	fi, err = os.Stat(filepath.Join(root, "home/user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("unexpected mode %v", fi.Mode())
+	}
+}
+
+func TestPathTraversal(t *testing.T) {
+	for _, name := range []string{"../evil", "etc/../../evil", "/../evil"} {
+		layers := []Layer{layer(registrytest.File{Name: name, Content: "evil"})}
+		if err := WriteTar(io.Discard, layers); err == nil {
+			t.Errorf("%s: expected an error writing a tar", name)
+		}
+		if err := ExtractDir(t.TempDir(), layers); err == nil {
+			t.Errorf("%s: expected an error extracting", name)
+		}
+	}
+
+	// symlinks of the image are resolved inside the root
+	dir := t.TempDir()
+	root := filepath.Join(dir, "rootfs")
+	for i, target := range []string{"/", "../../..", dir} {
+		link := fmt.Sprintf("escape%d", i)
+		err := ExtractDir(root, []Layer{
+			layer(registrytest.File{Name: link, Typeflag: tar.TypeSymlink, Linkname: target}),
+			layer(registrytest.File{Name: link + "/pwned", Content: "pwned"}),
+		})
+		if err != nil {
+			t.Fatalf("extract error: %+v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Errorf("entry written outside of the root")
+	}
+	if _, err := os.Stat(filepath.Join(root, "pwned")); err != nil {
+		t.Errorf("entry not written below the root: %+v", err)
+	}
+}
+
+func TestExtractDirReadOnly(t *testing.T) {
+	root := t.TempDir()
+	defer filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			os.Chmod(p, 0755)
+		}
+		return nil
+	})
+	err := ExtractDir(root, []Layer{
+		layer(
+			registrytest.File{Name: "ro/", Mode: 0555},
+			registrytest.File{Name: "ro/sub/", Mode: 0500},
+			registrytest.File{Name: "ro/sub/file", Content: "v1"},
+		),
+		layer(registrytest.File{Name: "ro/sub/file", Content: "v2"}),
+	})
+	if err != nil {
+		t.Fatalf("extract error: %+v", err)
+	}
+
+	for name, want := range map[string]os.FileMode{"ro": 0555, "ro/sub": 0500} {
+		fi, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("%s: unexpected mode %v", name, fi.Mode())
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(root, "ro/sub/file"))
+	if err != nil || string(content) != "v2" {
+		t.Errorf("unexpected content %q: %v", content, err)
+	}
+}
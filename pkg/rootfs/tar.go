@@ -0,0 +1,181 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+)
+
+// WriteTar writes the filesystem resulting of applying the layers in order as a single tar.
+// The layers are read twice: the first pass, from the top layer down, finds which entries remain visible,
+// the second one writes them from the bottom layer up so that hardlinks follow their target.
+func WriteTar(w io.Writer, layers []Layer) error {
+	scan, err := scanLayers(layers)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for i, layer := range layers {
+		// hidden hardlink targets are written under the name of their first visible link
+		renamed := make(map[string]string)
+		err = forEachEntry(layer, func(hdr *tar.Header, name string, r io.Reader) error {
+			out := *hdr
+			out.Name = name
+			if !scan.visible[i][name] {
+				links := scan.links[i][name]
+				if len(links) == 0 || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+					return nil
+				}
+				out.Name = links[0]
+				renamed[name] = links[0]
+			} else if hdr.Typeflag == tar.TypeLink {
+				linkname, _ := cleanPath(hdr.Linkname)
+				if target, ok := renamed[linkname]; ok {
+					if target == name {
+						return nil
+					}
+					linkname = target
+				}
+				out.Linkname = linkname
+			}
+			if hdr.Typeflag == tar.TypeDir {
+				out.Name += "/"
+			}
+			// let the writer pick a format able to hold the header
+			out.Format = tar.FormatUnknown
+
+			if err := tw.WriteHeader(&out); err != nil {
+				return fmt.Errorf("write %s error: %+v", out.Name, err)
+			}
+			if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+				if _, err := io.Copy(tw, r); err != nil {
+					return fmt.Errorf("write %s error: %+v", out.Name, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("layer %d: %+v", i, err)
+		}
+	}
+	return tw.Close()
+}
+
+type scanResult struct {
+	// visible holds, for each layer, the entries not removed or replaced by an upper layer
+	visible []map[string]bool
+	// links holds, for each layer, the visible hardlinks of each target
+	links []map[string][]string
+}
+
+func scanLayers(layers []Layer) (*scanResult, error) {
+	res := &scanResult{
+		visible: make([]map[string]bool, len(layers)),
+		links:   make([]map[string][]string, len(layers)),
+	}
+	// paths provided by the upper layers, true for directories whose content is merged with the lower layers
+	seen := make(map[string]bool)
+	// paths removed from the lower layers by a whiteout, and directories made opaque
+	removed := make(map[string]bool)
+	opaque := make(map[string]bool)
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		visible := make(map[string]bool)
+		links := make(map[string][]string)
+		// whiteouts and entries only apply to the lower layers
+		layerSeen := make(map[string]bool)
+		layerRemoved := make(map[string]bool)
+		layerOpaque := make(map[string]bool)
+
+		err := forEachEntry(layers[i], func(hdr *tar.Header, name string, _ io.Reader) error {
+			if target, isOpaque := whiteout(name); target != "" {
+				if isOpaque {
+					layerOpaque[target] = true
+				} else {
+					layerRemoved[target] = true
+				}
+				return nil
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				linkname, err := cleanPath(hdr.Linkname)
+				if err != nil || linkname == "" {
+					return fmt.Errorf("invalid hardlink target %s", hdr.Linkname)
+				}
+				if !hidden(name, seen, removed, opaque) {
+					links[linkname] = append(links[linkname], name)
+				}
+			}
+			if hidden(name, seen, removed, opaque) {
+				return nil
+			}
+			visible[name] = true
+			layerSeen[name] = hdr.Typeflag == tar.TypeDir
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %+v", i, err)
+		}
+		res.visible[i] = visible
+		res.links[i] = links
+
+		for name, isDir := range layerSeen {
+			seen[name] = isDir
+		}
+		for name := range layerRemoved {
+			removed[name] = true
+		}
+		for name := range layerOpaque {
+			opaque[name] = true
+		}
+	}
+	return res, nil
+}
+
+// hidden reports whether an entry of a lower layer is replaced or removed by the upper layers
+func hidden(name string, seen, removed, opaque map[string]bool) bool {
+	if _, ok := seen[name]; ok || removed[name] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if removed[dir] || opaque[dir] {
+			return true
+		}
+		// a file of an upper layer replaces the whole directory
+		if isDir, ok := seen[dir]; ok && !isDir {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachEntry calls fn with every entry of the layer and its clean path
+func forEachEntry(layer Layer, fn func(hdr *tar.Header, name string, r io.Reader) error) error {
+	r, err := layer()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read layer error: %+v", err)
+		}
+		name, err := cleanPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		if err = fn(hdr, name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package rootfs
+
+import (
+	"archive/tar"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"strings"
+)
+
+// setXattrs restores the extended attributes recorded in the PAX records of the entry
+func setXattrs(target string, hdr *tar.Header) error {
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPrefix) {
+			continue
+		}
+		attr := strings.TrimPrefix(key, xattrPrefix)
+		err := unix.Lsetxattr(target, attr, []byte(value), 0)
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			logrus.Warnf("skip xattr %s of %s: %+v", attr, hdr.Name, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mknod(target string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	case tar.TypeFifo:
+		mode |= unix.S_IFIFO
+	}
+	return unix.Mknod(target, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+}
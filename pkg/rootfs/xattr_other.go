@@ -0,0 +1,24 @@
+//go:build !linux
+
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// setXattrs warns about the extended attributes of the entry, they are only restored on linux
+func setXattrs(target string, hdr *tar.Header) error {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			logrus.Warnf("skip xattr %s of %s: not supported on this platform", strings.TrimPrefix(key, xattrPrefix), hdr.Name)
+		}
+	}
+	return nil
+}
+
+func mknod(target string, hdr *tar.Header) error {
+	return fmt.Errorf("device files are only supported on linux")
+}
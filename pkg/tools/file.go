@@ -4,6 +4,8 @@ import (
 	"archive/tar"
 	"bufio"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/jedib0t/go-pretty/v6/progress"
 	"github.com/sirupsen/logrus"
@@ -27,11 +29,11 @@ func (w *writeCounter) Write(p []byte) (int, error) {
 	return n, nil
 }
 
-func WriteBufferedFile(filename string, src io.ReadCloser, size int64, track *progress.Tracker) {
+func WriteBufferedFile(filename string, src io.ReadCloser, size int64, track *progress.Tracker) error {
 	defer src.Close()
 	file, err := os.Create(filename)
 	if err != nil {
-		logrus.Fatalf("create file %s error: %+v", filename, err)
+		return fmt.Errorf("create file %s error: %+v", filename, err)
 	}
 	fileWriter := bufio.NewWriter(file)
 	defer file.Close()
@@ -41,13 +43,16 @@ func WriteBufferedFile(filename string, src io.ReadCloser, size int64, track *pr
 	}
 	_, err = io.Copy(fileWriter, io.TeeReader(src, wc))
 	if err != nil {
-		logrus.Fatalf("write file %s error: %+v", filename, err)
+		return fmt.Errorf("write file %s error: %+v", filename, err)
+	}
+	if err = fileWriter.Flush(); err != nil {
+		return fmt.Errorf("write file %s error: %+v", filename, err)
 	}
-	fileWriter.Flush()
+	return file.Close()
 }
 
 func WriteFile(filename string, content []byte) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
 	defer func(file *os.File) {
 		err := file.Close()
 		if err != nil {
@@ -144,3 +149,17 @@ func TarDir(srcDir, destFile string) {
 		return err
 	})
 }
+
+func Sha256File(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("read file %s error: %+v", filename, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
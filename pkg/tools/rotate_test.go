@@ -0,0 +1,47 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "imsave.log")
+	r, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err = r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(name), content, want)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 backups expected, got %v", err)
+	}
+
+	// appends to the existing file
+	if r, err = OpenRotatingFile(path, 100, 2); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("fifth\n"))
+	r.Close()
+	if content, _ := os.ReadFile(path); !strings.HasSuffix(string(content), "fourth\nfifth\n") {
+		t.Errorf("got %q", content)
+	}
+}